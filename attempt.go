@@ -0,0 +1,39 @@
+package retryablehttp
+
+import (
+	"context"
+	"time"
+)
+
+// AttemptInfo describes the attempt being made by Client.Do. It is carried
+// by the context of the request handed to request modifiers, hooks and
+// CheckRetry, and can be read with AttemptInfoFromContext. Modifiers run
+// once, before the initial attempt, so they only ever see attempt 0.
+type AttemptInfo struct {
+	// Number is the attempt number, 0 for the initial request.
+	Number int
+
+	// Method is the HTTP method of the request, so that policies can tell
+	// whether it is safe to send again.
+	Method string
+
+	// Start is when the attempt started.
+	Start time.Time
+
+	// PrevErr and PrevStatus are the error and response status code of the
+	// previous attempt, if any.
+	PrevErr    error
+	PrevStatus int
+
+	// retryNonIdempotent carries Config.RetryNonIdempotent to the policies.
+	retryNonIdempotent bool
+}
+
+type attemptInfoKey struct{}
+
+// AttemptInfoFromContext returns the AttemptInfo of the attempt ctx belongs
+// to, if any.
+func AttemptInfoFromContext(ctx context.Context) (*AttemptInfo, bool) {
+	info, ok := ctx.Value(attemptInfoKey{}).(*AttemptInfo)
+	return info, ok
+}
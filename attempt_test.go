@@ -0,0 +1,82 @@
+package retryablehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAttemptInfoFromContext(t *testing.T) {
+	var hits int32
+	var signatures []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get("X-Signature"))
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(502)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	// A signer reading the attempt it signs for from the context.
+	client.RequestModifier = func(req *Request) *Request {
+		info, ok := AttemptInfoFromContext(req.Context())
+		if !ok {
+			t.Errorf("missing attempt info in the modifier")
+			return req
+		}
+		req.Header.Set("X-Signature", info.Method+":"+strconv.Itoa(info.Number))
+		return req
+	}
+
+	var numbers, prevStatuses []int
+	client.RequestLogHook = func(_ Logger, req *http.Request, _ int) {
+		info, ok := AttemptInfoFromContext(req.Context())
+		if !ok {
+			t.Errorf("missing attempt info")
+			return
+		}
+		if info.Start.IsZero() {
+			t.Errorf("missing attempt start")
+		}
+		numbers = append(numbers, info.Number)
+		prevStatuses = append(prevStatuses, info.PrevStatus)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(signatures) != 3 {
+		t.Fatalf("expected 3 attempts, got %v", signatures)
+	}
+	for _, sig := range signatures {
+		if sig != "GET:0" {
+			t.Fatalf("expected every attempt to carry the signature, got %v", signatures)
+		}
+	}
+	if numbers[0] != 0 || numbers[1] != 1 || numbers[2] != 2 {
+		t.Fatalf("bad attempt numbers: %v", numbers)
+	}
+	if prevStatuses[0] != 0 || prevStatuses[1] != 502 || prevStatuses[2] != 502 {
+		t.Fatalf("bad previous statuses: %v", prevStatuses)
+	}
+
+	if _, ok := AttemptInfoFromContext(context.Background()); ok {
+		t.Fatalf("expected no attempt info on a plain context")
+	}
+}
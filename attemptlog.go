@@ -0,0 +1,51 @@
+package retryablehttp
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// AttemptRecord is a single line written to Config.AttemptLog.
+type AttemptRecord struct {
+	Time    time.Time     `json:"ts"`
+	Method  string        `json:"method"`
+	URL     string        `json:"url"`
+	Attempt int           `json:"attempt"`
+	Status  int           `json:"status,omitempty"`
+	Err     string        `json:"err,omitempty"`
+	Wait    time.Duration `json:"wait"`
+}
+
+// logAttempt writes the outcome of attempt to AttemptLog when one is set.
+// wait is the backoff before the next attempt, or zero if there is none.
+func (c *Client) logAttempt(req *Request, attempt, status int, err error, wait time.Duration) {
+	if c.AttemptLog == nil {
+		return
+	}
+
+	rec := AttemptRecord{
+		Time:    time.Now(),
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Attempt: attempt,
+		Status:  status,
+		Wait:    wait,
+	}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+
+	line, jerr := json.Marshal(rec)
+	if jerr != nil {
+		c.logger(context.Background()).Error(jerr.Error())
+		return
+	}
+	line = append(line, '\n')
+
+	c.attemptLogMu.Lock()
+	defer c.attemptLogMu.Unlock()
+	if _, werr := c.AttemptLog.Write(line); werr != nil {
+		c.logger(context.Background()).Error(werr.Error())
+	}
+}
@@ -0,0 +1,65 @@
+package retryablehttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_AttemptLog(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	buf := new(bytes.Buffer)
+	client, err := New(&Config{AttemptLog: buf})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+
+	resp, err := client.Get(ts.URL + "/foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	var records []AttemptRecord
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var rec AttemptRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+
+	expect := []AttemptRecord{
+		{Method: "GET", URL: ts.URL + "/foo", Attempt: 0, Status: 503, Wait: 10 * time.Millisecond},
+		{Method: "GET", URL: ts.URL + "/foo", Attempt: 1, Status: 200},
+	}
+	if len(records) != len(expect) {
+		t.Fatalf("expected %d records, got: %#v", len(expect), records)
+	}
+	for i, rec := range records {
+		if rec.Time.IsZero() {
+			t.Fatalf("record %d: missing timestamp", i)
+		}
+		rec.Time = time.Time{}
+		if rec != expect[i] {
+			t.Fatalf("record %d: expected %#v, got %#v", i, expect[i], rec)
+		}
+	}
+}
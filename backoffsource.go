@@ -0,0 +1,119 @@
+package retryablehttp
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// Sources of retry waits, as logged in the backoff_source field.
+const (
+	BackoffSourceRetryAfter  = "retry-after"
+	BackoffSourceExponential = "exponential"
+	BackoffSourceJitter      = "jitter"
+	BackoffSourceConstant    = "constant"
+	BackoffSourceUnknown     = "unknown"
+)
+
+// BackoffSourcer describes what drove the wait a Backoff returned for resp,
+// such as BackoffSourceRetryAfter, so operators can tell waits apart in
+// the logs.
+type BackoffSourcer interface {
+	BackoffSource(resp *http.Response) string
+}
+
+// backoffSources maps the backoffs of this package to what drives their
+// waits. Each one is registered explicitly, functions up front and every
+// closure ConfigurableExponentialBackoff, ConfigurableExponentialJitterBackoff
+// or NewDecorrelatedJitterBackoff returns as it is handed out. They are
+// keyed by the address of their closure object rather than of their code,
+// which all closures made from the same literal share.
+var backoffSources sync.Map // closure address -> *backoffSourceEntry
+
+type backoffSourceEntry struct {
+	source func(resp *http.Response) string
+}
+
+func init() {
+	for _, b := range []struct {
+		backoff Backoff
+		source  func(*http.Response) string
+	}{
+		{DefaultBackoff, fixedSource(BackoffSourceExponential)},
+		{ExponentialJitterBackoff, fixedSource(BackoffSourceJitter)},
+		{LinearJitterBackoff, fixedSource(BackoffSourceJitter)},
+		{FullJitterBackoff, fixedSource(BackoffSourceJitter)},
+		{NoBackoff, fixedSource(BackoffSourceConstant)},
+		{RetryAfterBackoff, retryAfterSource},
+		{RateLimitHeaderBackoff, rateLimitHeaderSource},
+	} {
+		backoffSources.Store(uintptr(closureOf(b.backoff)), &backoffSourceEntry{b.source})
+	}
+}
+
+// closureOf returns the address of the closure object a Backoff value
+// points to. Every closure has its own, while a function has a single
+// static one.
+func closureOf(backoff Backoff) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&backoff))
+}
+
+// registerBackoffSource records source as what drives the waits of the
+// closure backoff and returns it. The record is dropped once the closure
+// is collected, unless its address was registered again meanwhile.
+func registerBackoffSource(backoff Backoff, source func(resp *http.Response) string) Backoff {
+	closure := closureOf(backoff)
+	key := uintptr(closure)
+	entry := &backoffSourceEntry{source}
+	backoffSources.Store(key, entry)
+	runtime.AddCleanup((*byte)(closure), func(entry *backoffSourceEntry) {
+		backoffSources.CompareAndDelete(key, entry)
+	}, entry)
+	return backoff
+}
+
+// backoffSourceOf returns what drove the wait backoff returned for resp, or
+// BackoffSourceUnknown if backoff wasn't registered.
+func backoffSourceOf(backoff Backoff, resp *http.Response) string {
+	if backoff == nil {
+		return BackoffSourceUnknown
+	}
+	entry, ok := backoffSources.Load(uintptr(closureOf(backoff)))
+	if !ok {
+		return BackoffSourceUnknown
+	}
+	return entry.(*backoffSourceEntry).source(resp)
+}
+
+// backoffSource returns what drove the wait the backoff for resp returned.
+// BackoffSourcer only describes Backoff, not RateLimitBackoff.
+func (c *Client) backoffSource(resp *http.Response) string {
+	if c.RateLimitBackoff != nil && SafeStatusCode(resp) == http.StatusTooManyRequests {
+		return backoffSourceOf(c.RateLimitBackoff, resp)
+	}
+	if c.BackoffSourcer != nil {
+		return c.BackoffSourcer.BackoffSource(resp)
+	}
+	return backoffSourceOf(c.Backoff, resp)
+}
+
+func fixedSource(source string) func(*http.Response) string {
+	return func(*http.Response) string { return source }
+}
+
+func retryAfterSource(resp *http.Response) string {
+	if _, ok := retryAfter(resp); ok {
+		return BackoffSourceRetryAfter
+	}
+	return BackoffSourceExponential
+}
+
+func rateLimitHeaderSource(resp *http.Response) string {
+	if SafeStatusCode(resp) == http.StatusForbidden || SafeStatusCode(resp) == http.StatusTooManyRequests {
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" && resp.Header.Get("X-RateLimit-Reset") != "" {
+			return BackoffSourceRetryAfter
+		}
+	}
+	return BackoffSourceExponential
+}
@@ -0,0 +1,93 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lalamove/nui/nlogger"
+)
+
+func TestClient_BackoffSource(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	buf := new(bytes.Buffer)
+	client, err := New(&Config{
+		Logger:  nlogger.New(buf, "[HTTP]"),
+		Backoff: RetryAfterBackoff,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = 0
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if out := buf.String(); !strings.Contains(out, "backoff_source=retry-after") {
+		t.Fatalf("expected retry-after backoff source in logs, got %q", out)
+	}
+}
+
+func TestClient_backoffSource_builtin(t *testing.T) {
+	cases := []struct {
+		backoff Backoff
+		expect  string
+	}{
+		{DefaultBackoff, BackoffSourceExponential},
+		{ConfigurableExponentialBackoff(3), BackoffSourceExponential},
+		{LinearJitterBackoff, BackoffSourceJitter},
+		{FullJitterBackoff, BackoffSourceJitter},
+		{NewDecorrelatedJitterBackoff(), BackoffSourceJitter},
+		{ExponentialJitterBackoff, BackoffSourceJitter},
+		{ConfigurableExponentialJitterBackoff(3), BackoffSourceJitter},
+		{NoBackoff, BackoffSourceConstant},
+		{RetryAfterBackoff, BackoffSourceExponential},
+		{func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration { return min }, BackoffSourceUnknown},
+	}
+	for i, tc := range cases {
+		client := &Client{Config: &Config{Backoff: tc.backoff}}
+		if source := client.backoffSource(nil); source != tc.expect {
+			t.Fatalf("%d: expected %q, got %q", i, tc.expect, source)
+		}
+	}
+}
+
+func TestRegisterBackoffSource_closures(t *testing.T) {
+	// Closures made from one literal share their code, but not their
+	// registration.
+	constant := func(d time.Duration) Backoff {
+		return func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+			return d
+		}
+	}
+	a := registerBackoffSource(constant(time.Second), fixedSource(BackoffSourceConstant))
+	b := registerBackoffSource(constant(time.Minute), fixedSource(BackoffSourceJitter))
+	c := constant(time.Hour)
+
+	if source := backoffSourceOf(a, nil); source != BackoffSourceConstant {
+		t.Fatalf("expected %q, got %q", BackoffSourceConstant, source)
+	}
+	if source := backoffSourceOf(b, nil); source != BackoffSourceJitter {
+		t.Fatalf("expected %q, got %q", BackoffSourceJitter, source)
+	}
+	if source := backoffSourceOf(c, nil); source != BackoffSourceUnknown {
+		t.Fatalf("expected an unregistered closure to be %q, got %q", BackoffSourceUnknown, source)
+	}
+}
@@ -0,0 +1,40 @@
+package retryablehttp
+
+import (
+	"context"
+	"io"
+)
+
+// ctxBody is a response body whose reads are bounded by a context.
+type ctxBody struct {
+	ctx  context.Context
+	body io.ReadCloser
+	stop func() bool
+}
+
+// contextBody wraps body so that reads fail with the context error once ctx
+// is done. The underlying body is closed as soon as ctx is done, which
+// unblocks a read stuck waiting on a slow server.
+func contextBody(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	return &ctxBody{
+		ctx:  ctx,
+		body: body,
+		stop: context.AfterFunc(ctx, func() { body.Close() }),
+	}
+}
+
+func (b *ctxBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := b.body.Read(p)
+	if err != nil && b.ctx.Err() != nil {
+		return n, b.ctx.Err()
+	}
+	return n, err
+}
+
+func (b *ctxBody) Close() error {
+	b.stop()
+	return b.body.Close()
+}
@@ -0,0 +1,93 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bodyRetryMatch reports whether the JSON value at BodyRetryJSONPath in the
+// body of resp is one of BodyRetryValues. Only JSON responses of up to
+// PeekResponseBodyLimit bytes are looked at. The body is restored so it can
+// still be read by the caller, unless reading it fails, in which case the
+// error is returned.
+func (c *Client) bodyRetryMatch(resp *http.Response) (bool, error) {
+	if resp == nil || resp.Body == nil || !isJSONContentType(resp.Header.Get("Content-Type")) {
+		return false, nil
+	}
+
+	body, whole, err := readBodyStart(resp, c.PeekResponseBodyLimit)
+	if err != nil || !whole {
+		return false, err
+	}
+
+	value, ok := jsonPointerValue(body, c.BodyRetryJSONPath)
+	if !ok {
+		return false, nil
+	}
+	for _, v := range c.BodyRetryValues {
+		if v == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// jsonPointerValue resolves the JSON pointer in data and returns the scalar
+// found there as a string. Numbers are returned as written in the document.
+// Objects, arrays and nulls, as well as pointers that don't resolve, return
+// false.
+func jsonPointerValue(data []byte, pointer string) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+
+	if pointer != "" {
+		if !strings.HasPrefix(pointer, "/") {
+			return "", false
+		}
+		for _, token := range strings.Split(pointer[1:], "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			switch node := doc.(type) {
+			case map[string]interface{}:
+				v, ok := node[token]
+				if !ok {
+					return "", false
+				}
+				doc = v
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(node) {
+					return "", false
+				}
+				doc = node[i]
+			default:
+				return "", false
+			}
+		}
+	}
+
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,186 @@
+package retryablehttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJSONPointerValue(t *testing.T) {
+	doc := []byte(`{"status":"RETRY","error":{"code":42,"ok":false},"items":[{"a/b":"x"}],"nil":null}`)
+	cases := []struct {
+		pointer string
+		expect  string
+		ok      bool
+	}{
+		{"/status", "RETRY", true},
+		{"/error/code", "42", true},
+		{"/error/ok", "false", true},
+		{"/items/0/a~1b", "x", true},
+		{"/items/1", "", false},
+		{"/error", "", false},
+		{"/nil", "", false},
+		{"/missing", "", false},
+		{"status", "", false},
+	}
+
+	for _, tc := range cases {
+		v, ok := jsonPointerValue(doc, tc.pointer)
+		if v != tc.expect || ok != tc.ok {
+			t.Fatalf("%s: expected %q %v, got %q %v", tc.pointer, tc.expect, tc.ok, v, ok)
+		}
+	}
+}
+
+func TestClient_BodyRetry(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Write([]byte(`{"status":"RETRY"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"OK"}`))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		BodyRetryJSONPath: "/status",
+		BodyRetryValues:   []string{"RETRY", "BUSY"},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if hits != 2 {
+		t.Fatalf("expected 2 requests, got %d", hits)
+	}
+
+	// The body of the final response is still readable.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != `{"status":"OK"}` {
+		t.Fatalf("bad body: %q", body)
+	}
+}
+
+func TestClient_BodyRetry_noMatch(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"FAILED"}`))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		BodyRetryJSONPath: "/status",
+		BodyRetryValues:   []string{"RETRY"},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if hits != 1 {
+		t.Fatalf("expected 1 request, got %d", hits)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != `{"status":"FAILED"}` {
+		t.Fatalf("bad body: %q", body)
+	}
+}
+
+func TestClient_BodyRetry_readError(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// Cut the body short.
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(`{"status":`))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write([]byte(`{"status":"OK"}`))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		BodyRetryJSONPath: "/status",
+		BodyRetryValues:   []string{"RETRY"},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The truncated body failed the attempt instead of being returned.
+	if hits != 2 || string(body) != `{"status":"OK"}` {
+		t.Fatalf("expected the second, whole body, got %q after %d requests", body, hits)
+	}
+}
+
+func TestClient_BodyRetry_limit(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"RETRY"}`))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		BodyRetryJSONPath:     "/status",
+		BodyRetryValues:       []string{"RETRY"},
+		PeekResponseBodyLimit: 8,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if hits != 1 || string(body) != `{"status":"RETRY"}` {
+		t.Fatalf("expected the long body to be returned whole, got %q after %d requests", body, hits)
+	}
+}
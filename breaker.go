@@ -0,0 +1,38 @@
+package retryablehttp
+
+import "errors"
+
+// ErrCircuitOpen is wrapped by the error Do returns when the CircuitBreaker
+// does not allow requests to the host.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker guards the hosts requests are made to. Do asks Allow
+// before sending a request, and reports the outcome of every request it
+// was allowed to send to Record.
+type CircuitBreaker interface {
+	// Allow reports whether a request to host may be sent. When the
+	// breaker is half-open it should allow a single request as a probe,
+	// reporting probe as true. A probe is attempted exactly once, without
+	// retries, so that its outcome reaches Record as soon as possible.
+	Allow(host string) (allowed, probe bool)
+
+	// Record reports whether a request to host, or a probe when probe is
+	// true, succeeded.
+	Record(host string, probe, success bool)
+}
+
+// allowRequest asks the CircuitBreaker, if any, whether req may be sent.
+func (c *Client) allowRequest(req *Request) (probe bool, err error) {
+	if c.CircuitBreaker == nil {
+		return false, nil
+	}
+	allowed, probe := c.CircuitBreaker.Allow(req.URL.Host)
+	if !allowed {
+		return false, &EarlyStopError{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Err:    ErrCircuitOpen,
+		}
+	}
+	return probe, nil
+}
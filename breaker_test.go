@@ -0,0 +1,102 @@
+package retryablehttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBreaker opens after a failure and lets a single probe through once
+// halfOpen is set.
+type testBreaker struct {
+	mu       sync.Mutex
+	open     bool
+	halfOpen bool
+	probing  bool
+}
+
+func (b *testBreaker) Allow(host string) (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case !b.open:
+		return true, false
+	case b.halfOpen && !b.probing:
+		b.probing = true
+		return true, true
+	}
+	return false, false
+}
+
+func (b *testBreaker) Record(host string, probe, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing, b.halfOpen = false, false
+	}
+	b.open = !success
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	var hits int32
+	var healthy int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	breaker := &testBreaker{}
+	client, err := New(&Config{CircuitBreaker: breaker})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	// A failing request is retried, then opens the breaker.
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatalf("expected error")
+	}
+	if hits != 3 || !breaker.open {
+		t.Fatalf("expected 3 requests and an open breaker, got %d, %v", hits, breaker.open)
+	}
+
+	// While open, nothing is sent.
+	if _, err := client.Get(ts.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if hits != 3 {
+		t.Fatalf("expected no request while open, got %d", hits)
+	}
+
+	// A failing probe is sent once, without retries, and reopens.
+	breaker.halfOpen = true
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatalf("expected error")
+	}
+	if hits != 4 || !breaker.open {
+		t.Fatalf("expected a single probe reopening the breaker, got %d requests, open %v", hits, breaker.open)
+	}
+
+	// A successful probe closes the breaker.
+	atomic.StoreInt32(&healthy, 1)
+	breaker.halfOpen = true
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if hits != 5 || breaker.open {
+		t.Fatalf("expected a single probe closing the breaker, got %d requests, open %v", hits, breaker.open)
+	}
+}
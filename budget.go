@@ -0,0 +1,29 @@
+package retryablehttp
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type retryBudgetKey struct{}
+
+// WithRetryBudget returns a copy of ctx carrying a budget of n retries shared
+// by every request made with it, or with any context derived from it, across
+// all clients. Once the budget is spent, requests stop retrying and give up
+// after their current attempt with an error wrapping
+// ErrRetryBudgetExhausted. This keeps a single inbound request from
+// spawning an unbounded number of downstream retries.
+func WithRetryBudget(ctx context.Context, n int) context.Context {
+	budget := int64(n)
+	return context.WithValue(ctx, retryBudgetKey{}, &budget)
+}
+
+// takeRetryBudget consumes one retry from the budget in ctx, if any. It
+// reports false once the budget is exhausted.
+func takeRetryBudget(ctx context.Context) bool {
+	budget, ok := ctx.Value(retryBudgetKey{}).(*int64)
+	if !ok {
+		return true
+	}
+	return atomic.AddInt64(budget, -1) >= 0
+}
@@ -0,0 +1,51 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithRetryBudget(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	ctx := WithRetryBudget(context.Background(), 2)
+	attempts := []int{3, 1}
+
+	for i := 0; i < 2; i++ {
+		client, err := New(&Config{RetryMax: 5})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+
+		req, err := NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		_, err = client.Do(req.WithContext(ctx))
+		if !errors.Is(err, ErrRetryBudgetExhausted) {
+			t.Fatalf("expected ErrRetryBudgetExhausted, got: %v", err)
+		}
+		var stopErr *EarlyStopError
+		if !errors.As(err, &stopErr) || stopErr.Attempts != attempts[i] {
+			t.Fatalf("expected to stop after %d attempts, got: %#v", attempts[i], stopErr)
+		}
+	}
+
+	// The first client spends the whole budget: 1 + 2 retries. The second
+	// one only makes its initial attempt.
+	if hits != 4 {
+		t.Fatalf("expected 4 requests, got %d", hits)
+	}
+}
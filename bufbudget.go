@@ -0,0 +1,154 @@
+package retryablehttp
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+)
+
+// ErrBufferBudgetExceeded is returned when buffering a request body would
+// take the bytes buffered across the process over the limit set with
+// SetBufferBudget.
+var ErrBufferBudgetExceeded = errors.New("request body buffer budget exceeded")
+
+// bufferBudget accounts for the request body bytes buffered in memory for
+// retries, process-wide.
+var bufferBudget struct {
+	sync.Mutex
+	limit int64
+	used  int64
+}
+
+// SetBufferBudget limits the total size of the request bodies which are
+// read into memory so they can be retried, such as plain io.Reader bodies
+// given to NewRequest, across all requests in progress in the process.
+// Bodies which would exceed it fail with ErrBufferBudgetExceeded. Bytes are
+// returned to the budget when Client.Do returns, and counted again if the
+// request is sent once more, or else when the request is closed with
+// Request.Close or garbage collected, for requests never sent. Zero, the
+// default, means no limit.
+func SetBufferBudget(n int64) {
+	bufferBudget.Lock()
+	bufferBudget.limit = n
+	bufferBudget.Unlock()
+}
+
+// BufferedBytes returns the number of request body bytes currently buffered
+// and counted against the budget.
+func BufferedBytes() int64 {
+	bufferBudget.Lock()
+	defer bufferBudget.Unlock()
+	return bufferBudget.used
+}
+
+// readAllBudgeted reads r in full, reserving the bytes read from the buffer
+// budget. It reads no more than the budget allows, plus one byte to detect
+// bodies which don't fit.
+func readAllBudgeted(r io.Reader) ([]byte, error) {
+	bufferBudget.Lock()
+	limit, available := bufferBudget.limit, bufferBudget.limit-bufferBudget.used
+	bufferBudget.Unlock()
+
+	if limit > 0 {
+		if available < 0 {
+			available = 0
+		}
+		r = io.LimitReader(r, available+1)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferBudget.Lock()
+	defer bufferBudget.Unlock()
+	if bufferBudget.limit > 0 && bufferBudget.used+int64(len(buf)) > bufferBudget.limit {
+		return nil, ErrBufferBudgetExceeded
+	}
+	bufferBudget.used += int64(len(buf))
+	return buf, nil
+}
+
+// budgetHold is the share of the budget held by the bodies buffered for a
+// Request. It is kept apart from the Request so that it can be returned once
+// the Request is garbage collected.
+type budgetHold struct {
+	n    int64 // bytes buffered, guarded by bufferBudget
+	held bool  // whether n counts against the budget, guarded by bufferBudget
+}
+
+func (h *budgetHold) release() {
+	bufferBudget.Lock()
+	defer bufferBudget.Unlock()
+	h.releaseLocked()
+}
+
+func (h *budgetHold) releaseLocked() {
+	if h.held {
+		bufferBudget.used -= h.n
+		h.held = false
+	}
+}
+
+// holdBuffered records n more bytes buffered for r against the budget,
+// which was reserved by readAllBudgeted.
+func (r *Request) holdBuffered(n int64) {
+	if n == 0 {
+		return
+	}
+	bufferBudget.Lock()
+	defer bufferBudget.Unlock()
+	if r.hold == nil {
+		r.hold = &budgetHold{}
+		runtime.AddCleanup(r, (*budgetHold).release, r.hold)
+	}
+	if !r.hold.held {
+		bufferBudget.used += r.hold.n
+		r.hold.held = true
+	}
+	r.hold.n += n
+}
+
+// acquireBuffered counts the bytes buffered for r against the budget again
+// for a Do call, if they were released since. It fails with
+// ErrBufferBudgetExceeded if they no longer fit.
+func (r *Request) acquireBuffered() error {
+	bufferBudget.Lock()
+	defer bufferBudget.Unlock()
+	if h := r.hold; h != nil && !h.held {
+		if bufferBudget.limit > 0 && bufferBudget.used+h.n > bufferBudget.limit {
+			return ErrBufferBudgetExceeded
+		}
+		bufferBudget.used += h.n
+		h.held = true
+	}
+	r.sending++
+	return nil
+}
+
+// doneBuffered ends a Do call started with acquireBuffered, returning the
+// bytes buffered for r to the budget once no call is in progress. It
+// reports whether that is the case.
+func (r *Request) doneBuffered() bool {
+	bufferBudget.Lock()
+	defer bufferBudget.Unlock()
+	r.sending--
+	if r.sending > 0 {
+		return false
+	}
+	if r.hold != nil {
+		r.hold.releaseLocked()
+	}
+	return true
+}
+
+// releaseBuffered returns the bytes buffered for r to the budget.
+func (r *Request) releaseBuffered() {
+	bufferBudget.Lock()
+	defer bufferBudget.Unlock()
+	if r.hold != nil {
+		r.hold.releaseLocked()
+	}
+}
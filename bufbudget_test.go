@@ -0,0 +1,97 @@
+package retryablehttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetBufferBudget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	SetBufferBudget(1000)
+	defer SetBufferBudget(0)
+
+	// A plain io.Reader is read into memory.
+	body := func() io.Reader {
+		return io.MultiReader(strings.NewReader(strings.Repeat("a", 600)))
+	}
+
+	first, err := NewRequest("POST", ts.URL, body())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := BufferedBytes(); n != 600 {
+		t.Fatalf("expected 600 buffered bytes, got %d", n)
+	}
+
+	if _, err := NewRequest("POST", ts.URL, body()); err != ErrBufferBudgetExceeded {
+		t.Fatalf("expected ErrBufferBudgetExceeded, got %v", err)
+	}
+	if n := BufferedBytes(); n != 600 {
+		t.Fatalf("expected a rejected body not to be counted, got %d", n)
+	}
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp, err := client.Do(first)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	// The budget is released once Do returns.
+	if n := BufferedBytes(); n != 0 {
+		t.Fatalf("expected the budget to be released after Do, got %d", n)
+	}
+
+	second, err := NewRequest("POST", ts.URL, body())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Sending the first request again needs its share back, which the
+	// second one now holds.
+	if _, err := client.Do(first); err != ErrBufferBudgetExceeded {
+		t.Fatalf("expected ErrBufferBudgetExceeded, got %v", err)
+	}
+	second.Close()
+	resp, err = client.Do(first)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if n := BufferedBytes(); n != 0 {
+		t.Fatalf("expected the budget to be released after Do, got %d", n)
+	}
+}
+
+func TestSetBufferBudget_neverSent(t *testing.T) {
+	SetBufferBudget(1000)
+	defer SetBufferBudget(0)
+
+	// Requests built but never sent nor closed, as when a builder bails
+	// out, give their share back once garbage collected.
+	for i := 0; i < 5; i++ {
+		if _, err := NewRequest("POST", "http://example.com", io.MultiReader(strings.NewReader(strings.Repeat("a", 600)))); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for BufferedBytes() != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("request %d: expected the budget to be released, got %d", i, BufferedBytes())
+			}
+			runtime.GC()
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
@@ -13,9 +13,11 @@
 // ReaderFunc which provides multiple io.Readers in an efficient manner, a
 // *bytes.Buffer (the underlying raw byte slice will be used) or a raw byte
 // slice. As it is a reference type, and we will wrap it as needed by readers,
-// we can efficiently re-use the request body without needing to copy it. If an
-// io.Reader (such as a *bytes.Reader) is provided, the full body will be read
-// prior to the first request, and will be efficiently re-used for any retries.
+// we can efficiently re-use the request body without needing to copy it. A
+// *bytes.Reader, *strings.Reader or regular *os.File is re-read in place for
+// each attempt with its length sent as Content-Length. If any other io.Reader
+// is provided, the full body will be read prior to the first request, and will
+// be efficiently re-used for any retries.
 // ReadSeeker can be used, but some users have observed occasional data races
 // between the net/http library and the Seek functionality of some
 // implementations of ReadSeeker, so should be avoided if possible.
@@ -24,20 +26,28 @@ package retryablehttp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
-	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/go-cleanhttp"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
 
 	"github.com/lalamove/nui/ntracing"
 
@@ -50,26 +60,110 @@ var (
 	defaultRetryWaitMax = 30 * time.Second
 	defaultRetryMax     = 4
 
+	// defaultRetryAfterCeiling bounds the Retry-After waits honored past
+	// RetryWaitMax when RespectRetryAfterOverMax is set.
+	defaultRetryAfterCeiling = 5 * time.Minute
+
+	defaultBackoffMultiplier = 2.0
+
+	// 501 Not Implemented and 505 HTTP Version Not Supported describe what
+	// the server is able to do rather than a passing failure, so retrying
+	// them only delays the inevitable.
+	defaultNonRetryable5xx = []int{
+		http.StatusNotImplemented,
+		http.StatusHTTPVersionNotSupported,
+	}
+
+	defaultPeekResponseBodyLimit int64 = 64 << 10
+
+	// minRetryWindow is the least time which must be left before a context
+	// deadline for another attempt to be worth making.
+	minRetryWindow = time.Millisecond
+
+	// defaultMaxRedirects matches the limit applied by net/http.
+	defaultMaxRedirects = 10
+
 	// We need to consume response bodies to maintain http connections, but
-	// limit the size we consume to respReadLimit.
+	// limit the size we consume to respReadLimit, unless ResponseDrainLimit
+	// says otherwise.
 	respReadLimit = int64(4096)
+
+	// permanentRequestErrors are the messages net/http fails malformed
+	// requests with. They are not exported as values, so they are matched
+	// by text.
+	permanentRequestErrors = []string{
+		"unsupported protocol scheme",
+		"no Host in request URL",
+	}
 )
 
+// ErrTooManyRedirects is wrapped by the error returned when a request
+// follows more than Config.MaxRedirects redirects. It is never retried by
+// DefaultRetryPolicy, as a redirect loop is not a transient failure.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
 // ReaderFunc is the type of function that can be given natively to NewRequest
 type ReaderFunc func() (io.Reader, error)
 
+// GiveUpHook is called once when Client.Do gives up on a request, whether
+// it ran out of retries or stopped early, with the outcome of the last
+// attempt. That includes the context ending during a backoff, the body
+// failing to rewind and the rate limiter refusing an attempt, in which
+// cases lastErr is the error Do returns. lastResp is nil when the attempt
+// failed without a response, and its body is still owned by Do.
+type GiveUpHook func(req *http.Request, lastResp *http.Response, lastErr error, attempts int)
+
 // LenReader is an interface implemented by many in-memory io.Reader's. Used
 // for automatically sending the right Content-Length header when possible.
 type LenReader interface {
 	Len() int
 }
 
+// BodyProvider can be given to NewRequest to plug in a custom strategy for
+// producing the request body on every attempt, for body sources NewRequest
+// doesn't know how to rewind, such as generated streams.
+type BodyProvider interface {
+	// NewReader returns a reader over the full body. It is called once
+	// per attempt and the reader is closed once the attempt is done.
+	NewReader() (io.ReadCloser, error)
+
+	// Len returns the length of the body and true if it is known, in
+	// which case it is sent as Content-Length.
+	Len() (int64, bool)
+}
+
 // Request wraps the metadata needed to create HTTP requests.
 type Request struct {
 	// body is a seekable reader over the request body payload. This is
 	// used to rewind the request data in between retries.
 	body ReaderFunc
 
+	// seeker is the raw body when it was given as an io.ReadSeeker, kept
+	// so that the client can buffer it up front if configured to.
+	seeker io.ReadSeeker
+
+	// source is the body given to NewRequest when it is rewound rather
+	// than read up front and needs closing, such as a file. It is closed
+	// once the last Do call in progress returns, unless keepSource is set,
+	// or by Close.
+	source     io.Closer
+	sourceOnce sync.Once
+	keepSource bool
+
+	// shared is the Request this one was cloned from for a Do call, if
+	// any. mu guards its body once shared.
+	shared *Request
+	mu     sync.Mutex
+
+	// noRetry limits the request to a single attempt.
+	noRetry bool
+
+	// hold is the share of the buffer budget held by the body, returned
+	// when Do returns, by Close or once the request is garbage collected.
+	// sending counts the Do calls in progress, guarded by bufferBudget.
+	hold    *budgetHold
+	sending int
+
 	// Embed an HTTP request directly. This makes a *Request act exactly
 	// like an *http.Request so that all meta methods are supported.
 	*http.Request
@@ -82,6 +176,21 @@ func (r *Request) WithContext(ctx context.Context) *Request {
 	return r
 }
 
+// DisableRetries makes Client.Do send this request exactly once, regardless
+// of the client's RetryMax and CheckRetry, and return its response as-is,
+// as with Config.DisableRetries. Use it for calls which must never be
+// repeated, such as payments, without building a separate client.
+func (r *Request) DisableRetries() {
+	r.noRetry = true
+}
+
+// KeepBodyOpen stops Client.Do from closing the body given to NewRequest
+// once it is done with the request, so that the request can be sent again.
+// The caller then closes the body, directly or with Close.
+func (r *Request) KeepBodyOpen() {
+	r.keepSource = true
+}
+
 // BodyBytes allows accessing the request body. It is an analogue to
 // http.Request's Body variable, but it returns a copy of the underlying data
 // rather than consuming it.
@@ -105,13 +214,45 @@ func (r *Request) BodyBytes() ([]byte, error) {
 }
 
 // NewRequest creates a new wrapped request.
+//
+// A ReaderFunc, or a func() (io.Reader, error), is called for a fresh body on
+// every attempt and is never buffered, which keeps memory flat for large
+// uploads, such as a file reopened on each call. NewRequest calls it once
+// more to find out the length of the body: when the reader is a LenReader
+// the length is sent as Content-Length, otherwise the body is sent chunked.
+// Any other io.Reader which can't be rewound is read into memory.
+//
+// Bodies rewound rather than read up front, such as an *os.File, are closed
+// if they implement io.Closer once Client.Do is done with the request, after
+// its last attempt, unless the request's KeepBodyOpen method was called.
+// Other bodies are left to the caller.
 func NewRequest(method, url string, rawBody interface{}) (*Request, error) {
+	return NewRequestWithContext(context.Background(), method, url, rawBody)
+}
+
+// NewRequestWithContext is like NewRequest, but the request carries ctx from
+// the start, as with http.NewRequestWithContext, sparing a call to
+// WithContext.
+func NewRequestWithContext(ctx context.Context, method, url string, rawBody interface{}) (*Request, error) {
 	var err error
 	var body ReaderFunc
+	var seeker io.ReadSeeker
 	var contentLength int64
+	var source io.Closer
+	var buffered int64
 
 	if rawBody != nil {
 		switch rawBody.(type) {
+		// Custom providers know best how to produce their body.
+		case BodyProvider:
+			provider := rawBody.(BodyProvider)
+			body = func() (io.Reader, error) {
+				return provider.NewReader()
+			}
+			if n, ok := provider.Len(); ok {
+				contentLength = n
+			}
+
 		// If they gave us a function already, great! Use it.
 		case ReaderFunc:
 			body = rawBody.(ReaderFunc)
@@ -157,22 +298,47 @@ func NewRequest(method, url string, rawBody interface{}) (*Request, error) {
 			}
 			contentLength = int64(buf.Len())
 
-		// We prioritize *bytes.Reader here because we don't really want to
-		// deal with it seeking so want it to match here instead of the
-		// io.ReadSeeker case.
+		// We prioritize *bytes.Reader and *strings.Reader here because we
+		// don't really want to deal with them seeking so want them to match
+		// here instead of the io.ReadSeeker case. Both support ReadAt, so
+		// every attempt gets its own section over the unread bytes without
+		// buffering them.
 		case *bytes.Reader:
-			buf, err := ioutil.ReadAll(rawBody.(*bytes.Reader))
+			raw := rawBody.(*bytes.Reader)
+			body, contentLength = sectionBody(raw, raw.Size()-int64(raw.Len()), int64(raw.Len()))
+
+		case *strings.Reader:
+			raw := rawBody.(*strings.Reader)
+			body, contentLength = sectionBody(raw, raw.Size()-int64(raw.Len()), int64(raw.Len()))
+
+		// Regular files know their size through Stat, so we can send the
+		// right Content-Length and read them through ReadAt. Anything else
+		// (pipes, sockets) is rewound by seeking and sent chunked.
+		case *os.File:
+			raw := rawBody.(*os.File)
+			info, err := raw.Stat()
 			if err != nil {
 				return nil, err
 			}
-			body = func() (io.Reader, error) {
-				return bytes.NewReader(buf), nil
+			source = raw
+			if !info.Mode().IsRegular() {
+				body = func() (io.Reader, error) {
+					raw.Seek(0, 0)
+					return ioutil.NopCloser(raw), nil
+				}
+				break
 			}
-			contentLength = int64(len(buf))
+			offset, err := raw.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			body, contentLength = sectionBody(raw, offset, info.Size()-offset)
 
 		// Compat case
 		case io.ReadSeeker:
 			raw := rawBody.(io.ReadSeeker)
+			seeker = raw
+			source, _ = raw.(io.Closer)
 			body = func() (io.Reader, error) {
 				raw.Seek(0, 0)
 				return ioutil.NopCloser(raw), nil
@@ -183,7 +349,7 @@ func NewRequest(method, url string, rawBody interface{}) (*Request, error) {
 
 		// Read all in so we can reset
 		case io.Reader:
-			buf, err := ioutil.ReadAll(rawBody.(io.Reader))
+			buf, err := readAllBudgeted(rawBody.(io.Reader))
 			if err != nil {
 				return nil, err
 			}
@@ -191,19 +357,115 @@ func NewRequest(method, url string, rawBody interface{}) (*Request, error) {
 				return bytes.NewReader(buf), nil
 			}
 			contentLength = int64(len(buf))
+			buffered = contentLength
 
 		default:
 			return nil, fmt.Errorf("cannot handle type %T", rawBody)
 		}
 	}
 
-	httpReq, err := http.NewRequest(method, url, nil)
+	req := &Request{body: body, seeker: seeker, source: source}
+	req.holdBuffered(buffered)
+	req.Request, err = http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
+		req.releaseBuffered()
 		return nil, err
 	}
-	httpReq.ContentLength = contentLength
+	req.ContentLength = contentLength
 
-	return &Request{body, httpReq}, nil
+	return req, nil
+}
+
+// FromRequest wraps r in a Request. Its body is read into memory, so that
+// it can be sent again on retries, and closed.
+func FromRequest(r *http.Request) (*Request, error) {
+	var body interface{}
+	if r.Body != nil && r.Body != http.NoBody {
+		defer r.Body.Close()
+		// Hide the concrete type so that the body is always buffered.
+		body = struct{ io.Reader }{r.Body}
+	}
+	req, err := NewRequest(r.Method, r.URL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	contentLength := req.ContentLength
+	req.Request = r.Clone(r.Context())
+	req.Body, req.GetBody = nil, nil
+	req.ContentLength = contentLength
+	return req, nil
+}
+
+// Close closes the body given to NewRequest when it is rewound rather than
+// read up front, such as an *os.File, if it implements io.Closer. Client.Do
+// does so itself unless KeepBodyOpen was called; call Close once done with
+// such a request, or one never sent. Close also returns the bytes buffered
+// for the request to the budget set with SetBufferBudget. Closing more than
+// once is a no-op.
+func (r *Request) Close() error {
+	r.releaseBuffered()
+	return r.closeSource()
+}
+
+// closeSource closes the body source of the request, once.
+func (r *Request) closeSource() error {
+	var err error
+	r.sourceOnce.Do(func() {
+		if r.source != nil {
+			err = r.source.Close()
+		}
+	})
+	return err
+}
+
+// clone returns a copy of r for a single Do call to work on, with its own
+// http.Request and headers, so that Do never mutates r and the same Request
+// can be sent by several goroutines at once. The body source and the bytes
+// buffered for it stay with r.
+func (r *Request) clone() *Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Request{
+		body:    r.body,
+		seeker:  r.seeker,
+		noRetry: r.noRetry,
+		shared:  r,
+		Request: r.Request.Clone(r.Context()),
+	}
+}
+
+// bufferSeeker reads the seekable body of r into memory so that retries are
+// served from the buffer instead of seeking. A clone buffers the body of the
+// Request it came from, once, so that later Do calls reuse the buffer.
+func (r *Request) bufferSeeker() error {
+	src := r
+	if r.shared != nil {
+		src = r.shared
+		src.mu.Lock()
+		defer src.mu.Unlock()
+	}
+	if src.seeker != nil {
+		buf, err := readAllBudgeted(src.seeker)
+		if err != nil {
+			return err
+		}
+		src.body = func() (io.Reader, error) {
+			return bytes.NewReader(buf), nil
+		}
+		src.seeker = nil
+		src.ContentLength = int64(len(buf))
+		src.holdBuffered(src.ContentLength)
+	}
+	r.body, r.seeker, r.ContentLength = src.body, nil, src.ContentLength
+	return nil
+}
+
+// sectionBody returns a ReaderFunc handing out a fresh reader over n bytes
+// of r starting at offset, along with the resulting content length.
+func sectionBody(r io.ReaderAt, offset, n int64) (ReaderFunc, int64) {
+	return func() (io.Reader, error) {
+		return io.NewSectionReader(r, offset, n), nil
+	}, n
 }
 
 // Logger interface allows to use other loggers than
@@ -234,7 +496,8 @@ type ResponseLogHook func(Logger, *http.Response)
 // that error value is returned in lieu of the error from the request. The
 // Client will close any response body when retrying, but if the retry is
 // aborted it is up to the CheckResponse callback to properly close any
-// response body before returning.
+// response body before returning. resp is nil when err is set, so policies
+// should read the status with SafeStatusCode.
 type CheckRetry func(ctx context.Context, resp *http.Response, err error) (bool, error)
 
 // Backoff specifies a policy for how long to wait between retries.
@@ -254,32 +517,281 @@ type Config struct {
 	RetryMax     int           // Maximum number of retries
 	RetryWaitMin time.Duration // Minimum time to wait in retries
 	RetryWaitMax time.Duration // Maximum time to wait in retries
-	Logger       Logger        // Customer logger instance to be used.
+	Logger       Logger        // Customer logger instance to be used, NopLogger for none.
+
+	// RetryMaxElapsedTime, if set, bounds the time Do spends retrying a
+	// request: once that long has passed since the first attempt, it
+	// stops with an error wrapping ErrRetryTimeExhausted even if retries
+	// are left, and backoff waits are cut short so as not to outlast it.
+	RetryMaxElapsedTime time.Duration
+
+	// RespectRetryAfterOverMax lets a Retry-After header of a 429 or 503
+	// response ask for a longer wait than RetryWaitMax, whatever Backoff
+	// is, up to RetryAfterCeiling, 5 minutes by default. By default waits
+	// never exceed RetryWaitMax, which bounds latency but may retry sooner
+	// than the server asked.
+	RespectRetryAfterOverMax bool
+	RetryAfterCeiling        time.Duration
+
+	// DisableRetries makes Do send every request exactly once, keeping
+	// logging, metrics and hooks, for a pass-through client. Like
+	// http.Client, Do then returns whatever response it gets with a nil
+	// error, without consulting CheckRetry. A RetryMax of 0 can't do this
+	// as it stands for the default.
+	DisableRetries bool
+
+	// Lean strips Do down to the retry loop for hot paths: nothing is
+	// logged, no tracing spans are started and metrics are not collected,
+	// even if Metrics is set. LatencyStats and LastStatus stay empty, the
+	// latter unless AdaptiveHostBackoff needs it. Hooks which are set
+	// explicitly still run. Do still copies the request and exposes
+	// AttemptInfo to policies, as retry decisions depend on them.
+	Lean bool
+
+	// MetricsPerStatusCode adds, when Metrics is enabled, a counter of
+	// responses labelled by their exact status code. Codes are limited to
+	// 0-599, so the counter has at most ~600 series.
+	MetricsPerStatusCode bool
+
+	// MetricsRegisterer is where the metrics enabled by Metrics are
+	// registered, prometheus.DefaultRegisterer if nil. Clients sharing a
+	// registerer report into the same series.
+	MetricsRegisterer prometheus.Registerer
+
+	// MetricsLabels are constant labels added to every metric of the
+	// client, such as {"service": "payments"}, to tell apart clients of
+	// different upstreams sharing a registerer. Each distinct set of
+	// values adds a set of series, so they must come from a fixed list,
+	// never from requests. Clients sharing a registerer must use the same
+	// label names.
+	MetricsLabels map[string]string
 
 	// HttpClient is the internal HTTP client.
 	HttpClient *http.Client
 
+	// CloseConnOnStatus, if set, is called with the status code of every
+	// response about to be retried. When it returns true the connection the
+	// response came on is closed, so the next attempt dials a fresh one.
+	CloseConnOnStatus func(code int) bool
+
+	// ReturnBestResponse makes the client, once retries are exhausted,
+	// return the best response seen across all attempts, the one with the
+	// lowest status code, instead of giving up with an error. Bodies of
+	// responses which may be returned are held in memory meanwhile.
+	ReturnBestResponse bool
+
+	// ReturnLastResponse makes Do, once retries are exhausted and no
+	// ErrorHandler is set, return the last response along with the error
+	// instead of closing it, so that callers can read the final status
+	// and body. The body is read into memory first, and the caller is then
+	// responsible for closing it. If reading it fails, no response is
+	// returned and the read error is joined to the error.
+	ReturnLastResponse bool
+
+	// VerifyDigest makes the client check response bodies against their
+	// Content-MD5 or Digest header, when present. A mismatch fails the
+	// attempt with an error wrapping ErrDigestMismatch, which is retried
+	// like any other error. Verified bodies are held in memory, up to
+	// 32MiB; longer ones are returned unverified. Responses whose body
+	// isn't the full representation, such as 206, 304 or HEAD responses,
+	// and bodies decompressed by the transport are not checked.
+	VerifyDigest bool
+
+	// StripBodyOnMethods lists methods, such as GET and HEAD, whose
+	// request bodies are dropped before sending, guarding against bodies
+	// attached by mistake which some upstreams reject. Empty by default.
+	StripBodyOnMethods []string
+
+	// ResponseTee, if set, is called for every response returned by Do,
+	// whose body is then copied to the returned writer as the caller reads
+	// it, for auditing. The writer is closed along with the body. Bodies
+	// of responses which are retried are not copied. Once a write fails,
+	// the error is logged and copying stops; the caller's reads are not
+	// affected.
+	ResponseTee func(req *http.Request) io.WriteCloser
+
+	// OnBodyRewind, if set, is called with the attempt number each time
+	// the request body is rewound for a retry, to observe how often large
+	// bodies are read again.
+	OnBodyRewind func(attempt int)
+
+	// BufferSeekableBodies makes the client read io.ReadSeeker request
+	// bodies into memory before the first attempt, so that retries are
+	// served from the buffer instead of seeking, which is racy for some
+	// ReadSeeker implementations.
+	BufferSeekableBodies bool
+
+	// URLRewriter, if set, returns the URL to send each attempt to, such
+	// as another host to fail over to. It is given a copy of the request
+	// URL which it may modify and return.
+	URLRewriter func(u *url.URL, attempt int) *url.URL
+
+	// PerAttemptTimeout, if set, bounds each attempt, so that a hung
+	// attempt is abandoned and retried instead of blocking until the
+	// request's own deadline, which still applies when it is sooner. The
+	// timeout covers reading the response body as well. CheckRetry sees
+	// the request context, so DefaultRetryPolicy retries timed out
+	// attempts like any other transport error.
+	PerAttemptTimeout time.Duration
+
+	// PerAttemptTimeoutFunc, if set, returns the timeout of each attempt,
+	// numbered from 0, instead of PerAttemptTimeout. This lets successive
+	// attempts wait longer on upstreams which slow down under load.
+	PerAttemptTimeoutFunc func(attempt int) time.Duration
+
+	// CircuitBreaker, if set, decides which requests may be sent and is
+	// told about their outcome. Probes it allows while half-open are sent
+	// without retries.
+	CircuitBreaker CircuitBreaker
+
+	// PerHostRateLimit, if set, returns the rate limiter for requests to
+	// the given host, or nil for none. It is called once per host. Every
+	// attempt waits for its host's limiter, so one slow upstream doesn't
+	// throttle the others. A request whose wait would outlast its
+	// deadline stops with an error wrapping ErrRateLimited.
+	PerHostRateLimit func(host string) *rate.Limiter
+
+	// MaxConcurrentRetries caps the number of retry attempts in progress
+	// at once across all requests of the client, so that recovery traffic
+	// after an outage doesn't arrive in one burst. First attempts are not
+	// limited. Retries wait for a free slot, or for their context to be
+	// done. Zero means no limit.
+	MaxConcurrentRetries int
+
+	// MaxRedirects caps the number of redirects followed by a single
+	// attempt, unless HttpClient has a CheckRedirect of its own. HttpClient
+	// itself is left untouched. Defaults to 10.
+	MaxRedirects int
+
 	// RequestModifier allows a user-supplied function to be called
-	// to modify a request object.
+	// to modify a request object. It runs once per call to Do, before the
+	// initial attempt, whose AttemptInfo its context carries.
 	RequestModifier RequestModifier
 
+	// RequestModifiers are applied in order after RequestModifier, each
+	// to the request returned by the previous one, so that independent
+	// layers, such as authentication and tracing, can each add theirs.
+	RequestModifiers []RequestModifier
+
 	// RequestLogHook allows a user-supplied function to be called
 	// before each retry.
 	RequestLogHook RequestLogHook
 
+	// ProgressHook, if set, is called as request bodies are sent, for
+	// progress reporting on large uploads.
+	ProgressHook ProgressHook
+
 	// ResponseLogHook allows a user-supplied function to be called
 	// with the response from each HTTP request executed.
 	ResponseLogHook ResponseLogHook
 
+	// RetryNonIdempotent lets the policies of this package retry transport
+	// errors of requests with a non-idempotent method, such as POST or
+	// PATCH, which may have reached the server before failing. They are
+	// returned by default, so that a payment isn't made twice. Errors
+	// connecting to the server are retried either way.
+	RetryNonIdempotent bool
+
 	// CheckRetry specifies the policy for handling retries, and is called
-	// after each request. The default policy is DefaultRetryPolicy.
+	// after each request. The default policy is DefaultRetryPolicy, using
+	// NonRetryable5xx as its set of permanent 5xx codes. Use
+	// RetryOnStatusCodes to also retry some 4xx codes.
 	CheckRetry CheckRetry
 
-	// Backoff specifies the policy for how long to wait between retries
+	// NonRetryable5xx lists the 500-range status codes the default policy
+	// treats as permanent and never retries. Defaults to 501 and 505.
+	NonRetryable5xx []int
+
+	// Backoff specifies the policy for how long to wait between retries.
+	// The default policy is ConfigurableExponentialBackoff using
+	// BackoffMultiplier.
 	Backoff Backoff
 
+	// BackoffSourcer reports what drove each wait of a custom Backoff in
+	// the retry logs. The backoffs of this package are recognized without
+	// it; other backoffs are logged as "unknown" when it is nil.
+	BackoffSourcer BackoffSourcer
+
+	// RateLimitBackoff, if set, is used instead of Backoff after 429 Too
+	// Many Requests responses, so that waits for rate limits can be tuned
+	// independently of waits for server errors.
+	RateLimitBackoff Backoff
+
+	// AdaptiveHostBackoff makes each Do call start its backoff further
+	// along the schedule by the number of consecutive failed calls to the
+	// same host, up to RetryMax, resetting once a call succeeds. Waits are
+	// then held to RetryWaitMax, and NewDecorrelatedJitterBackoff goes on
+	// from its previous wait rather than starting over from RetryWaitMin.
+	AdaptiveHostBackoff bool
+
+	// BackoffMultiplier is the growth factor of the default exponential
+	// backoff between attempts. Defaults to 2.0.
+	BackoffMultiplier float64
+
+	// BackoffJitter makes the default backoff, when Backoff is not set,
+	// wait a random 50% to 100% of each exponential wait, as
+	// ExponentialJitterBackoff does, so that clients which failed together
+	// don't retry in sync.
+	BackoffJitter bool
+
+	// GiveUpHook, if set, is called when Do gives up on a request, before
+	// ErrorHandler if any, to raise alerts or metrics about it without
+	// replacing ErrorHandler.
+	GiveUpHook GiveUpHook
+
 	// ErrorHandler specifies the custom error handler to use, if any
 	ErrorHandler ErrorHandler
+
+	// NoRetryHeader names a response header through which servers can ask
+	// clients to stop retrying. When it holds a true value (as understood
+	// by strconv.ParseBool) the response is returned as-is, whatever its
+	// status. It is unset by default, so that a header from an untrusted
+	// server can't turn retries off; X-No-Retry is a common choice.
+	NoRetryHeader string
+
+	// BodyRetryJSONPath is a JSON pointer (RFC 6901), such as "/status" or
+	// "/error/code", into JSON response bodies. When the value found there
+	// is one of BodyRetryValues the response is retried, even if
+	// CheckRetry would have returned it. Bodies longer than
+	// PeekResponseBodyLimit are not looked at. Failing to read the body
+	// fails the attempt.
+	BodyRetryJSONPath string
+	BodyRetryValues   []string
+
+	// PeekResponseBody lets CheckRetry read response bodies, such as a 200
+	// with {"error":"try again"}, without consuming them: up to
+	// PeekResponseBodyLimit bytes, 64KiB by default, are read into memory
+	// before CheckRetry is called and handed back ahead of the rest of the
+	// body afterwards. CheckRetry sees no more than the limit. This gives
+	// up streaming the start of every response and holds it in memory, so
+	// keep the limit as low as what CheckRetry needs.
+	PeekResponseBody      bool
+	PeekResponseBodyLimit int64
+
+	// ResponseDrainLimit caps how many bytes of a response body discarded
+	// before a retry are read so that its connection can be reused. Bodies
+	// longer than this are closed unread, which costs HTTP/1 a new
+	// connection. 0 uses a 4KiB limit, and a negative value drains bodies
+	// fully, however long.
+	ResponseDrainLimit int64
+
+	// TrailerRetryName names a response trailer, such as "grpc-status" for
+	// gRPC-Web, whose value decides whether a response is retried. When it
+	// is one of TrailerRetryValues, such as "14" (UNAVAILABLE), the
+	// response is retried even if CheckRetry would have returned it. As
+	// trailers follow the body, the body is buffered to read them; bodies
+	// longer than PeekResponseBodyLimit are not looked at. Failing to read
+	// the body fails the attempt.
+	TrailerRetryName   string
+	TrailerRetryValues []string
+
+	// StatsHook, if set, receives request statistics. It works alongside
+	// or instead of the Prometheus metrics enabled by Metrics.
+	StatsHook StatsHook
+
+	// AttemptLog, if set, receives one JSON object per attempt as
+	// newline-delimited JSON, for offline analysis of retry behavior.
+	AttemptLog io.Writer
 }
 
 func (c *Config) init() error {
@@ -289,17 +801,33 @@ func (c *Config) init() error {
 	if c.HttpClient == nil {
 		c.HttpClient = cleanhttp.DefaultClient()
 	}
-	if c.RetryMax <= 0 {
+	if c.PeekResponseBodyLimit <= 0 {
+		c.PeekResponseBodyLimit = defaultPeekResponseBodyLimit
+	}
+	if c.MaxRedirects <= 0 {
+		c.MaxRedirects = defaultMaxRedirects
+	}
+	if c.RetryWaitMin <= 0 {
 		c.RetryWaitMin = defaultRetryWaitMin
 	}
 	if c.RetryWaitMax <= 0 {
 		c.RetryWaitMax = defaultRetryWaitMax
 	}
+	if c.NonRetryable5xx == nil {
+		c.NonRetryable5xx = append([]int(nil), defaultNonRetryable5xx...)
+	}
 	if c.CheckRetry == nil {
-		c.CheckRetry = DefaultRetryPolicy
+		c.CheckRetry = NonRetryable5xxPolicy(c.NonRetryable5xx)
+	}
+	if c.BackoffMultiplier <= 0 {
+		c.BackoffMultiplier = defaultBackoffMultiplier
 	}
 	if c.Backoff == nil {
-		c.Backoff = DefaultBackoff
+		if c.BackoffJitter {
+			c.Backoff = ConfigurableExponentialJitterBackoff(c.BackoffMultiplier)
+		} else {
+			c.Backoff = ConfigurableExponentialBackoff(c.BackoffMultiplier)
+		}
 	}
 	if c.RetryMax <= 0 {
 		c.RetryMax = defaultRetryMax
@@ -307,6 +835,14 @@ func (c *Config) init() error {
 	return nil
 }
 
+// checkRedirect stops following redirects once MaxRedirects is reached.
+func (c *Config) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= c.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects: %w", len(via), ErrTooManyRedirects)
+	}
+	return nil
+}
+
 // Client is used to make HTTP requests. It adds additional functionality
 // like automatic retries to tolerate minor outages.
 type Client struct {
@@ -315,6 +851,31 @@ type Client struct {
 	// metrics is the internal metrics generated to be used for
 	// metric collection when enabled.
 	metrics *retryHttpMetrics
+
+	// statuses records the outcome of the most recent Do call per host.
+	statuses hostStatuses
+
+	// attemptLogMu serializes writes to AttemptLog.
+	attemptLogMu sync.Mutex
+
+	// counters are lightweight request counters which are always
+	// maintained, independently of Metrics.
+	counters counters
+
+	// latency is a histogram of Do call durations, for LatencyStats.
+	latency latencyHistogram
+
+	// retrySlots is a semaphore of MaxConcurrentRetries slots, nil when
+	// retries are not limited.
+	retrySlots chan struct{}
+
+	// hostLimiters holds the *rate.Limiter of each host, as given by
+	// PerHostRateLimit.
+	hostLimiters sync.Map
+
+	// initOnce guards lazyInit, for clients not made with New.
+	initOnce sync.Once
+	initErr  error
 }
 
 // New creates a new Client with default settings.
@@ -325,35 +886,249 @@ func New(c *Config) (*Client, error) {
 	}
 
 	var metrics *retryHttpMetrics
-	if c.Metrics {
-		metrics, err = initMetrics()
+	if c.Metrics && !c.Lean {
+		metrics, err = initMetrics(c)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return &Client{
+	client := &Client{
 		Config:  c,
 		metrics: metrics,
-	}, nil
+	}
+	if c.MaxConcurrentRetries > 0 {
+		client.retrySlots = make(chan struct{}, c.MaxConcurrentRetries)
+	}
+	client.initOnce.Do(func() {})
+	return client, nil
+}
+
+// lazyInit gives a Client built as a struct literal, as mocks often are,
+// the defaults New would have given it, except for metrics, so that it
+// can be used without panicking.
+func (c *Client) lazyInit() error {
+	c.initOnce.Do(func() {
+		if c.Config == nil {
+			c.Config = &Config{}
+		}
+		if c.initErr = c.Config.init(); c.initErr != nil {
+			return
+		}
+		if c.MaxConcurrentRetries > 0 {
+			c.retrySlots = make(chan struct{}, c.MaxConcurrentRetries)
+		}
+	})
+	return c.initErr
+}
+
+// httpClient returns the *http.Client attempts are sent with: HttpClient,
+// or a copy of it applying MaxRedirects when it has no CheckRedirect of its
+// own. HttpClient is never modified, as it may be shared, or even be
+// http.DefaultClient.
+func (c *Client) httpClient() *http.Client {
+	if c.HttpClient.CheckRedirect != nil {
+		return c.HttpClient
+	}
+	hc := *c.HttpClient
+	hc.CheckRedirect = c.checkRedirect
+	return &hc
 }
 
 // DefaultRetryPolicy provides a default callback for Client.CheckRetry, which
-// will retry on connection errors and server errors.
+// will retry on connection errors, 429 Too Many Requests and server errors,
+// except for 501 Not Implemented and 505 HTTP Version Not Supported which are
+// permanent. Transport errors no retry can fix, such as invalid certificates
+// or malformed requests, are not retried either, see IsRetryableError.
+// Neither are transport errors of requests
+// with a non-idempotent method such as POST, unless they failed to connect
+// or Config.RetryNonIdempotent is set, as the server may have acted on them.
+//
+// Pair it with RetryAfterBackoff, or set RateLimitBackoff, to wait as long as
+// rate limited responses ask. Callers who want 429 returned right away need a
+// CheckRetry of their own.
 func DefaultRetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	return retryPolicy(ctx, resp, err, defaultNonRetryable5xx)
+}
+
+// NonRetryable5xxPolicy returns a CheckRetry which behaves like
+// DefaultRetryPolicy but treats the given 500-range status codes as
+// permanent instead of 501 and 505.
+func NonRetryable5xxPolicy(codes []int) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		return retryPolicy(ctx, resp, err, codes)
+	}
+}
+
+// RetryOnStatusCodes returns a CheckRetry which behaves like
+// DefaultRetryPolicy but also retries responses with the given status
+// codes, such as 408 Request Timeout or 425 Too Early. It is a complete
+// policy, set as Client.CheckRetry in place of any other; a custom policy
+// which should retry these codes too has to check them itself.
+func RetryOnStatusCodes(codes ...int) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		// do not retry on context.Canceled or context.DeadlineExceeded
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		if err == nil {
+			status := SafeStatusCode(resp)
+			for _, code := range codes {
+				if status == code {
+					return true, nil
+				}
+			}
+		}
+		return DefaultRetryPolicy(ctx, resp, err)
+	}
+}
+
+// ConnectionErrorRetryPolicy provides a callback for Client.CheckRetry which
+// only retries transport errors known to be transient: a *net.OpError caused
+// by a connection reset, a refused connection or a broken pipe, as commonly
+// seen when load balancers cycle backends. Like with DefaultRetryPolicy,
+// non-idempotent requests are only retried when they can't have reached the
+// server. Any other error is returned without retrying. Responses are
+// handled like DefaultRetryPolicy.
+func ConnectionErrorRetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	// do not retry on context.Canceled or context.DeadlineExceeded
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	if err != nil {
+		if unsafeToResend(ctx, err) {
+			return false, err
+		}
+		return isTransientConnError(err), err
+	}
+	return DefaultRetryPolicy(ctx, resp, nil)
+}
+
+// unsafeToResend reports whether the attempt ctx belongs to sends a request
+// which is not idempotent as defined by RFC 7231, and which may have reached
+// the server before failing with err: a broken pipe or a timeout can come
+// after the server acted on it. It is false when the method is unknown or
+// Config.RetryNonIdempotent is set.
+func unsafeToResend(ctx context.Context, err error) bool {
+	info, ok := AttemptInfoFromContext(ctx)
+	if !ok || info.Method == "" || info.retryNonIdempotent || isIdempotent(info.Method) {
+		return false
+	}
+	return mayHaveBeenSent(err)
+}
+
+// mayHaveBeenSent reports whether any of the request could have been
+// written before err. Only failing to connect guarantees it wasn't.
+func mayHaveBeenSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+	var dnsErr *net.DNSError
+	return !errors.As(err, &dnsErr)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isTransientConnError(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	return errors.Is(opErr.Err, syscall.ECONNRESET) ||
+		errors.Is(opErr.Err, syscall.ECONNREFUSED) ||
+		errors.Is(opErr.Err, syscall.EPIPE)
+}
+
+// SafeStatusCode returns the status code of resp, or 0 when resp is nil as
+// it is on transport errors.
+func SafeStatusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// IsRetryableError reports whether a request failing with the transport
+// error err is worth retrying, as DefaultRetryPolicy does. Errors no retry
+// can fix are not: invalid TLS certificates, unknown hosts, redirect loops
+// and malformed requests. Everything else, such as timeouts and connection
+// resets, is.
+func IsRetryableError(err error) bool {
+	if errors.Is(err, ErrTooManyRedirects) || isPermanentRequestError(err) {
+		return false
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &invalidCert) || errors.As(err, &hostnameErr) {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return true
+}
+
+// isPermanentRequestError reports whether err says the request itself is
+// malformed, such as a URL with an unsupported scheme, which no retry can
+// fix.
+func isPermanentRequestError(err error) bool {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return false
+	}
+	msg := urlErr.Err.Error()
+	for _, permanent := range permanentRequestErrors {
+		if strings.Contains(msg, permanent) {
+			return true
+		}
+	}
+	return false
+}
+
+func retryPolicy(ctx context.Context, resp *http.Response, err error, nonRetryable []int) (bool, error) {
 	// do not retry on context.Canceled or context.DeadlineExceeded
 	if ctx.Err() != nil {
 		return false, ctx.Err()
 	}
 
 	if err != nil {
+		if !IsRetryableError(err) || unsafeToResend(ctx, err) {
+			return false, err
+		}
 		return true, err
 	}
 	// Check the response code. We retry on 500-range responses to allow
 	// the server time to recover, as 500's are typically not permanent
 	// errors and may relate to outages on the server side. This will catch
-	// invalid response codes as well, like 0 and 999.
-	if resp.StatusCode == 0 || (resp.StatusCode >= 500 && resp.StatusCode != 501) {
+	// invalid response codes as well, like 0 and 999. Codes which say the
+	// server can never handle the request are not retried. A 429 asks us
+	// to back off and try again, so it is retried too.
+	status := SafeStatusCode(resp)
+	if status == 0 || status == http.StatusTooManyRequests {
+		return true, nil
+	}
+	if status >= 500 {
+		for _, code := range nonRetryable {
+			if status == code {
+				return false, nil
+			}
+		}
 		return true, nil
 	}
 
@@ -364,7 +1139,44 @@ func DefaultRetryPolicy(ctx context.Context, resp *http.Response, err error) (bo
 // will perform exponential backoff based on the attempt number and limited
 // by the provided minimum and maximum durations.
 func DefaultBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
-	mult := math.Pow(2, float64(attemptNum)) * float64(min)
+	return exponentialBackoff(defaultBackoffMultiplier, min, max, attemptNum)
+}
+
+// ConfigurableExponentialBackoff returns a Backoff which performs exponential
+// backoff growing by the given multiplier on each attempt, limited by the
+// provided minimum and maximum durations. Multipliers below 2.0 give a
+// gentler ramp for latency-sensitive paths. A multiplier of 2.0 behaves
+// exactly like DefaultBackoff.
+func ConfigurableExponentialBackoff(multiplier float64) Backoff {
+	return registerBackoffSource(func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		return exponentialBackoff(multiplier, min, max, attemptNum)
+	}, fixedSource(BackoffSourceExponential))
+}
+
+// ExponentialJitterBackoff provides a callback for Client.Backoff which
+// applies "equal jitter" to DefaultBackoff: each wait is drawn uniformly
+// from [0.5, 1.0] times the exponential wait, so clients which failed
+// together don't retry in sync. It never exceeds max.
+func ExponentialJitterBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	return equalJitter(exponentialBackoff(defaultBackoffMultiplier, min, max, attemptNum))
+}
+
+// ConfigurableExponentialJitterBackoff is like ExponentialJitterBackoff,
+// with waits growing by the given multiplier.
+func ConfigurableExponentialJitterBackoff(multiplier float64) Backoff {
+	return registerBackoffSource(func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		return equalJitter(exponentialBackoff(multiplier, min, max, attemptNum))
+	}, fixedSource(BackoffSourceJitter))
+}
+
+// equalJitter returns a random duration in [wait/2, wait].
+func equalJitter(wait time.Duration) time.Duration {
+	half := float64(wait) / 2
+	return time.Duration(half + randFloat64()*half)
+}
+
+func exponentialBackoff(multiplier float64, min, max time.Duration, attemptNum int) time.Duration {
+	mult := math.Pow(multiplier, float64(attemptNum)) * float64(min)
 	sleep := time.Duration(mult)
 	if float64(sleep) != mult || sleep > max {
 		sleep = max
@@ -398,18 +1210,129 @@ func LinearJitterBackoff(min, max time.Duration, attemptNum int, resp *http.Resp
 		return min * time.Duration(attemptNum)
 	}
 
-	// Seed rand; doing this every time is fine
-	rand := rand.New(rand.NewSource(int64(time.Now().Nanosecond())))
-
 	// Pick a random number that lies somewhere between the min and max and
 	// multiply by the attemptNum. attemptNum starts at zero so we always
 	// increment here. We first get a random percentage, then apply that to the
 	// difference between min and max, and add to min.
-	jitter := rand.Float64() * float64(max-min)
+	jitter := randFloat64() * float64(max-min)
 	jitterMin := int64(jitter) + int64(min)
 	return time.Duration(jitterMin * int64(attemptNum))
 }
 
+// NoBackoff provides a callback for Client.Backoff which never waits,
+// whatever RetryWaitMin and RetryWaitMax are. It is meant for tests, which
+// can then exercise retries quickly and deterministically.
+func NoBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	return 0
+}
+
+// FullJitterBackoff provides a callback for Client.Backoff which follows
+// the "full jitter" recipe: the wait is drawn uniformly from [0, ceiling),
+// where the ceiling is min * 2^attemptNum limited by max. Waits average
+// half the ceiling and never exceed max, while concurrent clients retrying
+// the same backend are spread out instead of arriving in lockstep.
+func FullJitterBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	ceiling := exponentialBackoff(defaultBackoffMultiplier, min, max, attemptNum)
+	return time.Duration(randFloat64() * float64(ceiling))
+}
+
+// NewDecorrelatedJitterBackoff returns a Backoff following the
+// "decorrelated jitter" recipe: each wait is drawn uniformly from
+// [min, 3 * previous wait), limited by max, starting over from min on the
+// first retry of each request. The returned Backoff keeps the previous
+// wait, so concurrent requests sharing it influence each other's waits;
+// this is safe, and spreads them out further.
+func NewDecorrelatedJitterBackoff() Backoff {
+	var mu sync.Mutex
+	var prev time.Duration
+	return registerBackoffSource(func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		if attemptNum == 0 || prev < min {
+			prev = min
+		}
+		ceiling := 3 * prev
+		if ceiling > max || ceiling < 0 {
+			ceiling = max
+		}
+		sleep := min
+		if ceiling > min {
+			sleep += time.Duration(randFloat64() * float64(ceiling-min))
+		}
+		prev = sleep
+		return sleep
+	}, fixedSource(BackoffSourceJitter))
+}
+
+// RateLimitHeaderBackoff provides a callback for Client.Backoff which honors
+// GitHub-style rate limit headers. On a 403 or 429 response carrying
+// X-RateLimit-Remaining: 0 it waits until the Unix time given in
+// X-RateLimit-Reset, limited by max. In every other case it falls back to
+// DefaultBackoff.
+//
+// Note that DefaultRetryPolicy does not retry 403 responses, so this should
+// be paired with a CheckRetry which does for APIs rate limiting with 403.
+func RateLimitHeaderBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if status := SafeStatusCode(resp); (status == http.StatusForbidden || status == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			sleep := time.Until(time.Unix(reset, 0))
+			if sleep < min {
+				sleep = min
+			}
+			if sleep > max {
+				sleep = max
+			}
+			return sleep
+		}
+	}
+	return DefaultBackoff(min, max, attemptNum, resp)
+}
+
+// RetryAfterBackoff provides a callback for Client.Backoff which honors the
+// Retry-After header of 429 and 503 responses, limited to [min, max] and
+// to the time left before the request's deadline. In every other case it
+// falls back to DefaultBackoff.
+func RetryAfterBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	after, ok := retryAfter(resp)
+	if !ok {
+		return DefaultBackoff(min, max, attemptNum, resp)
+	}
+	if after < min {
+		after = min
+	}
+	if after > max {
+		after = max
+	}
+	if resp.Request != nil {
+		if deadline, ok := resp.Request.Context().Deadline(); ok {
+			if left := time.Until(deadline); after > left {
+				after = left
+			}
+		}
+	}
+	return after
+}
+
+// retryAfter returns the wait asked for by the Retry-After header of a 429
+// or 503 response, given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if status := SafeStatusCode(resp); status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at), true
+	}
+	return 0, false
+}
+
 // PassthroughErrorHandler is an ErrorHandler that directly passes through the
 // values from the net/http library for the final request. The body is not
 // closed.
@@ -417,37 +1340,177 @@ func PassthroughErrorHandler(resp *http.Response, err error, _ int) (*http.Respo
 	return resp, err
 }
 
-// Do wraps calling an HTTP method with retries.
+// Do wraps calling an HTTP method with retries. It works on a copy of req,
+// so the same Request may be sent by several goroutines at once, or again
+// if KeepBodyOpen was called or its body doesn't need closing. Otherwise the
+// body is closed once the last Do call in progress returns. A body rewound
+// by seeking, an io.ReadSeeker other than a regular file, a *bytes.Reader
+// or a *strings.Reader, has its position shared by all calls. The bytes
+// buffered for the body are kept, but only count against the budget set
+// with SetBufferBudget while Do calls are in progress.
 func (c *Client) Do(req *Request) (*http.Response, error) {
+	resp, _, err := c.DoWithAttempts(req)
+	return resp, err
+}
+
+// DoWithAttempts is like Do, but also returns the number of HTTP round trips
+// made, including the initial one.
+func (c *Client) DoWithAttempts(req *Request) (*http.Response, int, error) {
+	if err := c.lazyInit(); err != nil {
+		return nil, 0, err
+	}
+	if err := req.acquireBuffered(); err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if req.doneBuffered() && !req.keepSource {
+			req.closeSource()
+		}
+	}()
+	host := req.URL.Host
+	start := time.Now()
+	c.counters.requests.Add(1)
+	probe, err := c.allowRequest(req)
+	var resp *http.Response
+	var attempts int
+	call := req.clone()
+	if err == nil {
+		resp, attempts, err = c.do(call, probe)
+		c.teeResponse(req.Request, resp)
+		if c.CircuitBreaker != nil {
+			c.CircuitBreaker.Record(host, probe, err == nil)
+		}
+	}
+	elapsed := time.Since(start)
+	if !c.Lean {
+		c.latency.observe(elapsed)
+	}
+	if c.StatsHook != nil {
+		c.StatsHook.Done(elapsed, err)
+	}
+	if err != nil {
+		c.counters.failures.Add(1)
+	} else {
+		c.counters.successes.Add(1)
+	}
+	if !c.Lean || c.AdaptiveHostBackoff {
+		c.statuses.record(host, err == nil)
+	}
+	return resp, attempts, err
+}
+
+// modifyRequest applies RequestModifier, then RequestModifiers, to req. The
+// modifiers see the AttemptInfo of the initial attempt in its context.
+func (c *Client) modifyRequest(req *Request) *Request {
+	if c.RequestModifier == nil && len(c.RequestModifiers) == 0 {
+		return req
+	}
+	req.Request = req.Request.WithContext(context.WithValue(req.Context(), attemptInfoKey{}, &AttemptInfo{
+		Method: req.Method,
+		Start:  time.Now(),
+
+		retryNonIdempotent: c.RetryNonIdempotent,
+	}))
+	if c.RequestModifier != nil {
+		req = c.RequestModifier(req)
+	}
+	for _, modify := range c.RequestModifiers {
+		req = modify(req)
+	}
+	return req
+}
+
+func (c *Client) do(req *Request, probe bool) (*http.Response, int, error) {
 	if c.metrics != nil {
 		c.metrics.doTotal.Inc()
 		var timer = prometheus.NewTimer(c.metrics.doDuration)
 		defer timer.ObserveDuration()
 	}
 
-	// If modifier is provided then modify request.
-	if c.RequestModifier != nil {
-		req = c.RequestModifier(req)
-	}
+	req = c.modifyRequest(req)
 
 	var ctx = req.Context()
-	if span, ok := ntracing.NewChildSpanFromContext(ctx, "HttpClient.Do"); ok {
+	logger := c.logger(ctx)
+	if c.Lean {
+		logger = NopLogger{}
+	} else if span, ok := ntracing.NewChildSpanFromContext(ctx, "HttpClient.Do"); ok {
 		defer span.Finish()
 
 		ctx = context.WithValue(ctx, ntracing.SpanKey, span)
 		req.WithContext(ctx)
 	}
 
-	c.Logger.DebugWithFields("Sending request for method", func(entry nlogger.Entry) {
+	if req.body != nil && c.stripsBody(req.Method) {
+		logger.DebugWithFields("stripping request body", func(entry nlogger.Entry) {
+			entry.String("method", req.Method)
+			entry.String("url", req.URL.String())
+		})
+		req.body = nil
+		req.seeker = nil
+		req.Request.Body = nil
+		req.ContentLength = 0
+	}
+
+	// Read seekable bodies in once up front so retries never have to seek.
+	if c.BufferSeekableBodies && req.seeker != nil {
+		if err := req.bufferSeeker(); err != nil {
+			if c.metrics != nil {
+				c.metrics.doFailure.Inc()
+			}
+			return nil, 0, err
+		}
+	}
+
+	// A zero Content-Length with a body means the length is unknown and
+	// the body will be sent chunked.
+	contentLength := req.ContentLength
+	if contentLength == 0 && req.body != nil {
+		contentLength = -1
+	}
+	logger.DebugWithFields("Sending request for method", func(entry nlogger.Entry) {
 		entry.String("method", req.Method)
 		entry.String("url", req.URL.String())
+		entry.Int64("content_length", contentLength)
+		entry.String("content_type", req.Header.Get("Content-Type"))
 	})
 
 	var resp *http.Response
 	var err error
 
+	// Without retries the client passes responses through like
+	// http.Client, rather than judging them with CheckRetry.
+	passthrough := c.DisableRetries || req.noRetry
+	retryMax := c.retryMax(req.Context())
+	if passthrough || probe {
+		retryMax = 0
+	}
+
+	// Pick up the backoff where previous failing calls to this host left
+	// off, so a down upstream isn't hammered from RetryWaitMin every time.
+	var backoffOffset int
+	if c.AdaptiveHostBackoff {
+		backoffOffset = c.consecutiveFailures(req.URL.Host)
+		if backoffOffset > c.RetryMax {
+			backoffOffset = c.RetryMax
+		}
+	}
+
+	var attempts int
+	var roundTrips int      // attempts actually sent
+	var best *http.Response // best response seen, when ReturnBestResponse is set
+	var prevErr error
+	var prevStatus int
+	var statuses []int // status of each attempt, for MaxRetriesError
+	var stopErr error  // why retries stopped early, if they did
+	baseCtx := req.Context()
 	var retryTimer *prometheus.Timer
+	httpClient := c.httpClient()
+	firstAttempt := time.Now()
 	for i := 0; ; i++ {
+		attempts = i + 1
+		if i > 0 {
+			c.counters.retries.Add(1)
+		}
 		if c.metrics != nil && i > 0 {
 			retryTimer = prometheus.NewTimer(c.metrics.doRetryDuration)
 			c.metrics.doRetries.Inc()
@@ -457,6 +1520,9 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 
 		// Always rewind the request body when non-nil.
 		if req.body != nil {
+			if i > 0 && c.OnBodyRewind != nil {
+				c.OnBodyRewind(i)
+			}
 			body, err := req.body()
 			if err != nil {
 				if retryTimer != nil {
@@ -470,27 +1536,134 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 						c.metrics.doRetriesFailure.Inc()
 					}
 				}
-				return resp, err
+				if best != nil {
+					best.Body.Close()
+				}
+				if c.GiveUpHook != nil {
+					c.GiveUpHook(req.Request, nil, err, i)
+				}
+				return resp, roundTrips, err
 			}
 			if c, ok := body.(io.ReadCloser); ok {
 				req.Request.Body = c
 			} else {
 				req.Request.Body = ioutil.NopCloser(body)
 			}
+			if c.ProgressHook != nil {
+				req.Request.Body = &progressBody{
+					ReadCloser: req.Request.Body,
+					hook:       c.ProgressHook,
+					total:      req.ContentLength,
+				}
+			}
 		}
 
+		// Expose the attempt to hooks and policies through the context.
+		req.Request = req.Request.WithContext(context.WithValue(baseCtx, attemptInfoKey{}, &AttemptInfo{
+			Number:     i,
+			Method:     req.Method,
+			Start:      time.Now(),
+			PrevErr:    prevErr,
+			PrevStatus: prevStatus,
+
+			retryNonIdempotent: c.RetryNonIdempotent,
+		}))
+
 		if c.RequestLogHook != nil {
-			c.RequestLogHook(c.Logger, req.Request, i)
+			c.RequestLogHook(logger, req.Request, i)
+		}
+
+		if err := c.waitRateLimit(baseCtx, req.URL.Host); err != nil {
+			if c.metrics != nil {
+				c.metrics.doFailure.Inc()
+			}
+			if best != nil {
+				best.Body.Close()
+			}
+			if err == ErrRateLimited {
+				err = &EarlyStopError{
+					Method:   req.Method,
+					URL:      req.URL.String(),
+					Attempts: roundTrips,
+					Err:      err,
+				}
+			}
+			if c.GiveUpHook != nil {
+				c.GiveUpHook(req.Request, nil, err, i)
+			}
+			return nil, roundTrips, err
 		}
 
 		// Attempt the request
-		resp, err = c.HttpClient.Do(req.Request)
-		if resp != nil {
-			code = resp.StatusCode
+		attemptStart := time.Now()
+		httpReq, timing := traceAttempt(c.rewriteURL(req.Request, i))
+		httpReq, cancel := c.withAttemptTimeout(httpReq, i)
+		var conn net.Conn // connection of the attempt, for CloseConnOnStatus
+		if c.CloseConnOnStatus != nil {
+			httpReq = httpReq.WithContext(httptrace.WithClientTrace(httpReq.Context(), &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) { conn = info.Conn },
+			}))
+		}
+		var release func()
+		if release, err = c.acquireRetrySlot(baseCtx, i); err == nil {
+			resp, err = httpClient.Do(httpReq)
+			roundTrips++
+			release()
+		} else {
+			resp = nil
+		}
+		if resp != nil && resp.Body != nil {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		} else {
+			cancel()
+		}
+		code = SafeStatusCode(resp)
+		statuses = append(statuses, code)
+		if resp != nil && c.metrics != nil {
+			c.metrics.observeStatus(code)
+		}
+		if err == nil && c.VerifyDigest {
+			if err = verifyDigest(resp); err != nil {
+				resp.Body.Close()
+				resp = nil
+			}
+		}
+		if timing != nil {
+			timing.done(i, code, err)
+		}
+		if c.StatsHook != nil {
+			c.StatsHook.Attempt(i, time.Since(attemptStart), err)
 		}
 
 		// Check if we should continue with retries.
-		checkOK, checkErr := c.CheckRetry(req.Request.Context(), resp, err)
+		var checkOK bool
+		var checkErr error
+		if !passthrough {
+			restoreBody := func() {}
+			if c.PeekResponseBody {
+				restoreBody = c.peekBody(resp)
+			}
+			checkOK, checkErr = c.CheckRetry(req.Request.Context(), resp, err)
+			restoreBody()
+			var readErr error // failure reading the body to inspect it
+			if !checkOK && checkErr == nil && err == nil && c.BodyRetryJSONPath != "" {
+				checkOK, readErr = c.bodyRetryMatch(resp)
+			}
+			if !checkOK && checkErr == nil && err == nil && readErr == nil && c.TrailerRetryName != "" {
+				checkOK, readErr = c.trailerRetryMatch(resp)
+			}
+			if readErr != nil {
+				// The response can't be returned whole, so the attempt failed.
+				resp, err = nil, readErr
+				checkOK, checkErr = c.CheckRetry(req.Request.Context(), nil, err)
+			}
+		}
+		if checkOK && resp != nil && c.NoRetryHeader != "" {
+			// The server asked us to stop, so hand back its response as-is.
+			if noRetry, _ := strconv.ParseBool(resp.Header.Get(c.NoRetryHeader)); noRetry {
+				checkOK, checkErr, err = false, nil, nil
+			}
+		}
 
 		if retryTimer != nil {
 			retryTimer.ObserveDuration()
@@ -502,7 +1675,7 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 				c.metrics.doRetriesFailure.Inc()
 			}
 
-			c.Logger.ErrorWithFields(err.Error(), func(entry nlogger.Entry) {
+			logger.ErrorWithFields(err.Error(), func(entry nlogger.Entry) {
 				entry.String("method", req.Method)
 				entry.String("url", req.URL.String())
 			})
@@ -511,12 +1684,13 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 			// even if CheckRetry signals to stop.
 			if c.ResponseLogHook != nil {
 				// Call the response logger function if provided.
-				c.ResponseLogHook(c.Logger, resp)
+				c.ResponseLogHook(logger, resp)
 			}
 		}
 
 		// Now decide if we should continue.
 		if !checkOK {
+			c.logAttempt(req, i, code, err, 0)
 			if checkErr != nil {
 				err = checkErr
 			}
@@ -528,43 +1702,222 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 					c.metrics.doSuccess.Inc()
 				}
 			}
-			return resp, err
+
+			// Track requests which only succeeded thanks to a retry.
+			if err == nil && i > 0 {
+				c.counters.recovered.Add(1)
+				if c.metrics != nil {
+					c.metrics.doRecovered.Inc()
+				}
+				logger.DebugWithFields("http request recovered by retry", func(entry nlogger.Entry) {
+					entry.Int("attempts", attempts)
+					entry.String("method", req.Method)
+					entry.String("url", req.URL.String())
+				})
+			}
+			if best != nil {
+				best.Body.Close()
+			}
+			return resp, roundTrips, err
 		}
 
 		// We do this before drainBody beause there's no need for the I/O if
 		// we're breaking out
-		remain := c.RetryMax - i
+		remain := retryMax - i
+		if remain > 0 && c.RetryMaxElapsedTime > 0 && time.Since(firstAttempt) >= c.RetryMaxElapsedTime {
+			stopErr = ErrRetryTimeExhausted
+			remain = 0
+		}
+		if remain > 0 && !takeRetryBudget(req.Context()) {
+			stopErr = ErrRetryBudgetExhausted
+			remain = 0
+		}
 		if remain <= 0 {
+			c.logAttempt(req, i, code, err, 0)
 			if c.metrics != nil && err != nil {
 				c.metrics.doFailure.Inc()
 			}
 			break
 		}
 
-		// We're going to retry, consume any response to reuse the connection.
+		// Don't bother retrying when there's no time left for another
+		// attempt before the context deadline.
+		if deadline, ok := req.Context().Deadline(); ok {
+			left := time.Until(deadline)
+			if left < minRetryWindow {
+				c.logAttempt(req, i, code, err, 0)
+				logger.DebugWithFields("no time left for retrying http request", func(entry nlogger.Entry) {
+					entry.String("method", req.Method)
+					entry.String("url", req.URL.String())
+				})
+				stopErr = ErrNoTimeLeft
+				break
+			}
+			// The server won't be ready before the deadline, so retrying
+			// earlier than it asked is pointless.
+			if after, ok := retryAfter(resp); ok && after > left {
+				c.logAttempt(req, i, code, err, 0)
+				logger.DebugWithFields("retry-after exceeds the deadline, not retrying http request", func(entry nlogger.Entry) {
+					entry.String("method", req.Method)
+					entry.String("retry_after", after.String())
+					entry.String("url", req.URL.String())
+				})
+				stopErr = ErrRateLimited
+				break
+			}
+		}
+
+		// We're going to retry, consume any response to reuse the connection,
+		// unless the status says the connection shouldn't be reused.
+		// When keeping the best response around, its body is read in full
+		// instead.
 		if err == nil && resp != nil {
-			c.drainBody(resp.Body)
+			closeConn := c.CloseConnOnStatus != nil && c.CloseConnOnStatus(resp.StatusCode)
+			drained := false
+			switch {
+			case c.ReturnBestResponse && (best == nil || resp.StatusCode < best.StatusCode):
+				// A body that can't be read whole is no candidate.
+				if bufErr := c.bufferBody(resp); bufErr != nil {
+					logger.Error(bufErr.Error())
+					break
+				}
+				if best != nil {
+					best.Body.Close()
+				}
+				best = resp
+				drained = true
+			case closeConn:
+				resp.Body.Close()
+				if conn != nil {
+					conn.Close()
+				}
+			default:
+				drained = c.drainBody(resp.Body)
+			}
+			if drained && timing != nil {
+				timing.trace.markDrained(i)
+			}
 		}
 
-		wait := c.Backoff(c.RetryWaitMin, c.RetryWaitMax, i, resp)
-		desc := fmt.Sprintf("%s %s", req.Method, req.URL)
-		if code > 0 {
-			desc = fmt.Sprintf("%s (status: %d)", desc, code)
+		wait := c.backoff(resp)(c.RetryWaitMin, c.RetryWaitMax, i+backoffOffset, resp)
+		if backoffOffset > 0 && wait > c.RetryWaitMax {
+			// Backoffs which don't clamp, such as LinearJitterBackoff,
+			// would otherwise grow with every failed call.
+			wait = c.RetryWaitMax
+		}
+		wait = c.retryAfterOverMax(wait, resp)
+		if c.RetryMaxElapsedTime > 0 {
+			if left := c.RetryMaxElapsedTime - time.Since(firstAttempt); wait > left {
+				wait = left
+			}
 		}
 
-		c.Logger.DebugWithFields("retrying http request", func(entry nlogger.Entry) {
+		// Never sleep past the context deadline.
+		if deadline, ok := req.Context().Deadline(); ok {
+			if left := time.Until(deadline); wait > left {
+				wait = left
+			}
+		}
+		c.logAttempt(req, i, code, err, wait)
+
+		// The fields are only built if the logger asks for them, which the
+		// NopLogger used in Lean mode never does.
+		logger.DebugWithFields("retrying http request", func(entry nlogger.Entry) {
+			desc := fmt.Sprintf("%s %s", req.Method, req.URL)
+			if code > 0 {
+				desc = fmt.Sprintf("%s (status: %d)", desc, code)
+			}
 			entry.Int("remain", remain)
+			entry.String("backoff_source", c.backoffSource(resp))
 			entry.String("desc", desc)
 			entry.String("method", req.Method)
 			entry.String("wait", wait.String())
 			entry.String("url", req.URL.String())
 		})
 
-		time.Sleep(wait)
+		prevErr, prevStatus = err, code
+
+		// Wait, unless the caller gives up on the request in the meantime.
+		waitStart := time.Now()
+		timer := time.NewTimer(wait)
+		canceled := false
+		select {
+		case <-timer.C:
+		case <-baseCtx.Done():
+			timer.Stop()
+			canceled = true
+		}
+		if c.metrics != nil {
+			c.metrics.doBackoff.Observe(time.Since(waitStart).Seconds())
+		}
+		if canceled {
+			if c.metrics != nil {
+				c.metrics.doFailure.Inc()
+			}
+			if best != nil {
+				best.Body.Close()
+			}
+			if c.GiveUpHook != nil {
+				c.GiveUpHook(req.Request, nil, baseCtx.Err(), attempts)
+			}
+			return nil, roundTrips, baseCtx.Err()
+		}
+	}
+
+	if best != nil {
+		if resp == nil || err != nil || best.StatusCode < resp.StatusCode {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			resp, err = best, nil
+		} else {
+			best.Body.Close()
+		}
+	}
+
+	if c.GiveUpHook != nil {
+		c.GiveUpHook(req.Request, resp, err, attempts)
 	}
 
 	if c.ErrorHandler != nil {
-		return c.ErrorHandler(resp, err, c.RetryMax+1)
+		resp, err = c.ErrorHandler(resp, err, attempts)
+		return resp, roundTrips, err
+	}
+
+	if c.ReturnBestResponse && resp != nil && err == nil {
+		if c.metrics != nil {
+			c.metrics.doFailure.Inc()
+		}
+		return resp, roundTrips, nil
+	}
+
+	if c.metrics != nil {
+		c.metrics.doFailure.Inc()
+	}
+	var giveUpErr error
+	if stopErr != nil {
+		giveUpErr = &EarlyStopError{
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Attempts: attempts,
+			Err:      stopErr,
+		}
+	} else {
+		giveUpErr = &MaxRetriesError{
+			Method:         req.Method,
+			URL:            req.URL.String(),
+			Attempts:       attempts,
+			LastStatusCode: statuses[len(statuses)-1],
+			LastErr:        err,
+			Statuses:       statuses,
+		}
+	}
+
+	if c.ReturnLastResponse && resp != nil {
+		if bufErr := c.bufferBody(resp); bufErr != nil {
+			return nil, roundTrips, errors.Join(giveUpErr, bufErr)
+		}
+		return resp, roundTrips, giveUpErr
 	}
 
 	// By default, we close the response body and return an error without
@@ -572,37 +1925,141 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 	if resp != nil {
 		resp.Body.Close()
 	}
+	return nil, roundTrips, giveUpErr
+}
 
-	if c.metrics != nil {
-		c.metrics.doFailure.Inc()
+// backoff returns the Backoff to use after resp.
+func (c *Client) backoff(resp *http.Response) Backoff {
+	if c.RateLimitBackoff != nil && SafeStatusCode(resp) == http.StatusTooManyRequests {
+		return c.RateLimitBackoff
+	}
+	return c.Backoff
+}
+
+// retryAfterOverMax lengthens wait to the Retry-After of resp, up to
+// RetryAfterCeiling, when RespectRetryAfterOverMax is set and the server
+// asked for more than RetryWaitMax.
+func (c *Client) retryAfterOverMax(wait time.Duration, resp *http.Response) time.Duration {
+	if !c.RespectRetryAfterOverMax {
+		return wait
+	}
+	after, ok := retryAfter(resp)
+	if !ok || after <= c.RetryWaitMax || after <= wait {
+		return wait
+	}
+	ceiling := c.RetryAfterCeiling
+	if ceiling <= 0 {
+		ceiling = defaultRetryAfterCeiling
 	}
-	return nil, fmt.Errorf("%s %s giving up after %d attempts",
-		req.Method, req.URL, c.RetryMax+1)
+	if after > ceiling {
+		after = ceiling
+	}
+	if after < wait {
+		return wait
+	}
+	return after
+}
+
+// stripsBody reports whether request bodies are dropped for method.
+func (c *Client) stripsBody(method string) bool {
+	for _, m := range c.StripBodyOnMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedule returns the waits the client's backoff would produce between
+// attempts given resp, one per retry, without making any request. It makes
+// backoff configuration easy to inspect and test. It returns nil if the
+// client's Config is invalid.
+func (c *Client) Schedule(resp *http.Response) []time.Duration {
+	if err := c.lazyInit(); err != nil {
+		return nil
+	}
+	schedule := make([]time.Duration, 0, c.RetryMax)
+	for i := 0; i < c.RetryMax; i++ {
+		schedule = append(schedule, c.retryAfterOverMax(c.backoff(resp)(c.RetryWaitMin, c.RetryWaitMax, i, resp), resp))
+	}
+	return schedule
+}
+
+// bufferBody reads the body of resp into memory so it can be returned
+// later, freeing up the connection in the meantime. If the body can't be
+// read whole, it is closed and the error returned.
+func (c *Client) bufferBody(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
 }
 
 // Try to read the response body so we can reuse this connection.
-func (c *Client) drainBody(body io.ReadCloser) {
+// It reports whether the body was read to the end, which is what allows the
+// connection to be reused. Reading stops past ResponseDrainLimit bytes,
+// respReadLimit by default, unless it is negative.
+func (c *Client) drainBody(body io.ReadCloser) bool {
 	defer body.Close()
-	_, err := io.Copy(ioutil.Discard, io.LimitReader(body, respReadLimit))
-	if err != nil {
-		if c.Logger != nil {
-			c.Logger.Error(err.Error())
+	limit := c.ResponseDrainLimit
+	if limit == 0 {
+		limit = respReadLimit
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	// Read through the pooled buffer's spare capacity rather than into the
+	// buffer itself, so that long bodies don't grow it.
+	scratch := buf.Bytes()[:buf.Cap()]
+	var n int64
+	for limit < 0 || n <= limit {
+		m, err := body.Read(scratch)
+		n += int64(m)
+		if err == io.EOF {
+			return limit < 0 || n <= limit
+		}
+		if err != nil {
+			c.logger(context.Background()).Error(err.Error())
+			return false
 		}
 	}
+	return false
 }
 
 // Get is a convenience helper for doing simple GET requests.
 func (c *Client) Get(url string) (*http.Response, error) {
-	req, err := NewRequest("GET", url, nil)
+	req, err := NewGet(url)
 	if err != nil {
 		return nil, err
 	}
 	return c.Do(req)
 }
 
+// GetConditional is a convenience helper for conditional GET requests
+// against polling APIs. The given etag, if non-empty, is sent in the
+// If-None-Match header. On a 304 Not Modified response it returns the
+// response and true; a 304 is never treated as an error or retried.
+func (c *Client) GetConditional(ctx context.Context, url, etag string) (*http.Response, bool, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req = req.WithContext(ctx)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return resp, false, err
+	}
+	return resp, resp.StatusCode == http.StatusNotModified, nil
+}
+
 // Head is a convenience method for doing simple HEAD requests.
 func (c *Client) Head(url string) (*http.Response, error) {
-	req, err := NewRequest("HEAD", url, nil)
+	req, err := NewHead(url)
 	if err != nil {
 		return nil, err
 	}
@@ -611,16 +2068,91 @@ func (c *Client) Head(url string) (*http.Response, error) {
 
 // Post is a convenience method for doing simple POST requests.
 func (c *Client) Post(url, bodyType string, body interface{}) (*http.Response, error) {
-	req, err := NewRequest("POST", url, body)
+	req, err := NewPost(url, bodyType, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", bodyType)
 	return c.Do(req)
 }
 
 // PostForm is a convenience method for doing simple POST operations using
 // pre-filled url.Values form data.
 func (c *Client) PostForm(url string, data url.Values) (*http.Response, error) {
-	return c.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	req, err := NewPostForm(url, data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Put is a convenience method for doing simple PUT requests.
+func (c *Client) Put(url, bodyType string, body interface{}) (*http.Response, error) {
+	req, err := NewPut(url, bodyType, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Patch is a convenience method for doing simple PATCH requests.
+func (c *Client) Patch(url, bodyType string, body interface{}) (*http.Response, error) {
+	req, err := NewPatch(url, bodyType, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Delete is a convenience method for doing simple DELETE requests.
+func (c *Client) Delete(url string) (*http.Response, error) {
+	req, err := NewDelete(url)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// NewGet builds the request sent by Client.Get, without sending it.
+func NewGet(url string) (*Request, error) {
+	return NewRequest("GET", url, nil)
+}
+
+// NewHead builds the request sent by Client.Head, without sending it.
+func NewHead(url string) (*Request, error) {
+	return NewRequest("HEAD", url, nil)
+}
+
+// NewPost builds the request sent by Client.Post, without sending it.
+func NewPost(url, bodyType string, body interface{}) (*Request, error) {
+	return newRequestWithType("POST", url, bodyType, body)
+}
+
+// NewPut builds the request sent by Client.Put, without sending it.
+func NewPut(url, bodyType string, body interface{}) (*Request, error) {
+	return newRequestWithType("PUT", url, bodyType, body)
+}
+
+// NewPatch builds the request sent by Client.Patch, without sending it.
+func NewPatch(url, bodyType string, body interface{}) (*Request, error) {
+	return newRequestWithType("PATCH", url, bodyType, body)
+}
+
+// NewDelete builds the request sent by Client.Delete, without sending it.
+func NewDelete(url string) (*Request, error) {
+	return NewRequest("DELETE", url, nil)
+}
+
+func newRequestWithType(method, url, bodyType string, body interface{}) (*Request, error) {
+	req, err := NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", bodyType)
+	return req, nil
+}
+
+// NewPostForm builds the request sent by Client.PostForm, without sending
+// it.
+func NewPostForm(url string, data url.Values) (*Request, error) {
+	return NewPost(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
 }
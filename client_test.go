@@ -3,6 +3,8 @@ package retryablehttp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -12,11 +14,18 @@ import (
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/go-cleanhttp"
+
 	"github.com/lalamove/nui/nlogger"
 )
 
@@ -52,6 +61,389 @@ func TestRequest(t *testing.T) {
 	}
 }
 
+func TestNewRequestWithContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+
+	req, err := NewRequestWithContext(ctx, "POST", "http://foo", []byte("yo"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if req.Context() != ctx {
+		t.Fatalf("expected the request to carry the given context")
+	}
+	if req.ContentLength != 2 {
+		t.Fatalf("expected content length 2, got %d", req.ContentLength)
+	}
+
+	if _, err := NewRequestWithContext(nil, "GET", "http://foo", nil); err == nil {
+		t.Fatalf("expected an error for a nil context")
+	}
+}
+
+func TestRequest_ContentLength(t *testing.T) {
+	f, err := ioutil.TempFile("", "retryablehttp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("hello world"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	partial := bytes.NewReader([]byte("hello world"))
+	partial.Seek(6, 0)
+
+	cases := []struct {
+		name   string
+		body   interface{}
+		expect int64
+	}{
+		{"strings.Reader", strings.NewReader("hello"), 5},
+		{"bytes.Reader", bytes.NewReader([]byte("hello")), 5},
+		{"partially read bytes.Reader", partial, 5},
+		{"os.File", f, 11},
+		{"unknown reader", ReaderFunc(func() (io.Reader, error) {
+			return &custReader{}, nil
+		}), 0},
+	}
+
+	for _, tc := range cases {
+		req, err := NewRequest("PUT", "http://foo", tc.body)
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.name, err)
+		}
+		if req.ContentLength != tc.expect {
+			t.Fatalf("%s: bad ContentLength: %d", tc.name, req.ContentLength)
+		}
+	}
+}
+
+// zeroStream generates n zero bytes without holding them in memory.
+type zeroStream struct {
+	n int64
+}
+
+func (s *zeroStream) Read(p []byte) (int, error) {
+	if s.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.n {
+		p = p[:s.n]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	s.n -= int64(len(p))
+	return len(p), nil
+}
+
+// sizedZeroStream is a zeroStream which knows its length.
+type sizedZeroStream struct {
+	zeroStream
+}
+
+func (s *sizedZeroStream) Len() int {
+	return int(s.n)
+}
+
+func TestClient_Do_readerFuncStreams(t *testing.T) {
+	const size = 8 << 20
+
+	for _, sized := range []bool{false, true} {
+		var hits int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n := BufferedBytes(); n != 0 {
+				t.Errorf("expected nothing buffered, got %d bytes", n)
+			}
+			if sized && r.ContentLength != size {
+				t.Errorf("expected ContentLength %d, got %d", size, r.ContentLength)
+			}
+			if !sized && r.ContentLength != -1 {
+				t.Errorf("expected a chunked body, got ContentLength %d", r.ContentLength)
+			}
+			if n, _ := io.Copy(ioutil.Discard, r.Body); n != size {
+				t.Errorf("expected %d bytes, got %d", size, n)
+			}
+			if atomic.AddInt32(&hits, 1) == 1 {
+				w.WriteHeader(500)
+			}
+		}))
+
+		var opens int32
+		body := ReaderFunc(func() (io.Reader, error) {
+			atomic.AddInt32(&opens, 1)
+			if sized {
+				return &sizedZeroStream{zeroStream{n: size}}, nil
+			}
+			return &zeroStream{n: size}, nil
+		})
+
+		client, err := New(&Config{
+			RetryWaitMin: time.Millisecond,
+			RetryWaitMax: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		req, err := NewRequest("PUT", ts.URL, body)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		// NewRequest opens the body once to find its length, then every
+		// attempt opens its own.
+		if n := atomic.LoadInt32(&opens); n != 3 {
+			t.Fatalf("expected 3 opens, got %d", n)
+		}
+	}
+}
+
+// countingProvider is a BodyProvider regenerating the same bytes on every
+// attempt.
+type countingProvider struct {
+	readers int32
+}
+
+func (p *countingProvider) NewReader() (io.ReadCloser, error) {
+	atomic.AddInt32(&p.readers, 1)
+	return ioutil.NopCloser(strings.NewReader("generated")), nil
+}
+
+func (p *countingProvider) Len() (int64, bool) {
+	return int64(len("generated")), true
+}
+
+func TestClient_Do_bodyProvider(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength != 9 {
+			t.Errorf("bad ContentLength: %d", r.ContentLength)
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+		if string(body) != "generated" {
+			t.Errorf("bad body: %q", body)
+		}
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	provider := &countingProvider{}
+	req, err := NewRequest("POST", ts.URL, provider)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if hits != 3 {
+		t.Fatalf("expected 3 requests, got: %d", hits)
+	}
+	if provider.readers != 3 {
+		t.Fatalf("expected a reader per attempt, got: %d", provider.readers)
+	}
+}
+
+func TestClient_Do_fileBody(t *testing.T) {
+	f, err := ioutil.TempFile("", "retryablehttp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("hello"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength != 5 {
+			t.Errorf("bad ContentLength: %d", r.ContentLength)
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+		if string(body) != "hello" {
+			t.Errorf("bad body: %q", body)
+		}
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+
+	req, err := NewRequest("PUT", ts.URL, f)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req.KeepBodyOpen()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got: %d", attempts)
+	}
+
+	// The file is left open, so the request can be sent again.
+	atomic.StoreInt32(&attempts, 0)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	// Close hands the file over.
+	if err := req.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := f.Seek(0, 0); err == nil {
+		t.Fatalf("expected the file to be closed")
+	}
+}
+
+func TestClient_Do_closesFileBody(t *testing.T) {
+	f, err := ioutil.TempFile("", "retryablehttp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("hello"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	req, err := NewRequest("PUT", ts.URL, f)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	// The file was closed once Do was done with it.
+	if _, err := f.Seek(0, 0); err == nil {
+		t.Fatalf("expected the file to be closed")
+	}
+}
+
+// closeCountingReader is a ReadSeekCloser recording calls to Close.
+type closeCountingReader struct {
+	*strings.Reader
+	closes int32
+}
+
+func (r *closeCountingReader) Close() error {
+	atomic.AddInt32(&r.closes, 1)
+	return nil
+}
+
+func TestClient_Do_closesSeekerBody(t *testing.T) {
+	body := &closeCountingReader{Reader: strings.NewReader("hello")}
+
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.LoadInt32(&body.closes); n != 0 {
+			t.Errorf("body closed %d times before the last attempt", n)
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil || string(data) != "hello" {
+			t.Errorf("bad body: %q, %v", data, err)
+		}
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer ts.Close()
+
+	for _, fail := range []bool{false, true} {
+		atomic.StoreInt32(&attempts, 0)
+		atomic.StoreInt32(&body.closes, 0)
+		retryMax := 2
+		if fail {
+			retryMax = 1
+		}
+		client, err := New(&Config{
+			RetryMax:     retryMax,
+			RetryWaitMin: time.Millisecond,
+			RetryWaitMax: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+
+		req, err := NewRequest("PUT", ts.URL, body)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp, err := client.Do(req)
+		if fail != (err != nil) {
+			t.Fatalf("expected failure %v, got %#v", fail, err)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		req.Close()
+		if n := atomic.LoadInt32(&body.closes); n != 1 {
+			t.Fatalf("expected the body to be closed once, got %d", n)
+		}
+	}
+}
+
 // Since normal ways we would generate a Reader have special cases, use a
 // custom type here
 type custReader struct {
@@ -141,7 +533,7 @@ func testClient_Do(t *testing.T, body interface{}) {
 		var err error
 		resp, err = client.Do(req)
 		if err != nil {
-			t.Fatalf("err: %v", err)
+			t.Errorf("err: %v", err)
 		}
 	}()
 
@@ -218,321 +610,378 @@ func testClient_Do(t *testing.T, body interface{}) {
 	}
 }
 
-func TestClient_Do_fails(t *testing.T) {
-	// Mock server which always responds 500.
+// noSeekReader is an io.ReadSeeker which must never be seeked.
+type noSeekReader struct {
+	*custReader
+}
+
+func (noSeekReader) Seek(int64, int) (int64, error) {
+	panic("Seek called")
+}
+
+func TestClient_Do_bufferSeekableBodies(t *testing.T) {
+	var hits int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(500)
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+		if string(body) != "hello" {
+			t.Errorf("bad body: %q", body)
+		}
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
 	}))
 	defer ts.Close()
 
-	// Create the client. Use short retry windows so we fail faster.
-	client, err := New(&Config{})
+	client, err := New(&Config{BufferSeekableBodies: true})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
 	client.RetryWaitMin = 10 * time.Millisecond
 	client.RetryWaitMax = 10 * time.Millisecond
-	client.RetryMax = 2
 
-	// Create the request
-	req, err := NewRequest("POST", ts.URL, nil)
+	req, err := NewRequest("PUT", ts.URL, noSeekReader{&custReader{}})
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
 
-	// Send the request.
-	_, err = client.Do(req)
-	if err == nil || !strings.Contains(err.Error(), "giving up") {
-		t.Fatalf("expected giving up error, got: %#v", err)
+	if hits != 2 {
+		t.Fatalf("expected 2 requests, got: %d", hits)
+	}
+	if req.ContentLength != 5 {
+		t.Fatalf("bad ContentLength: %d", req.ContentLength)
 	}
 }
 
-func TestClient_Get(t *testing.T) {
+func TestClient_Do_sharedRequest(t *testing.T) {
+	var hits int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			t.Fatalf("bad method: %s", r.Method)
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
 		}
-		if r.RequestURI != "/foo/bar" {
-			t.Fatalf("bad uri: %s", r.RequestURI)
+		if string(body) != "hello" {
+			t.Errorf("bad body: %q", body)
+		}
+		if len(r.Header["X-Attempt"]) != 1 {
+			t.Errorf("expected a single X-Attempt header, got %v", r.Header["X-Attempt"])
+		}
+		// Fail the first requests so that some calls retry.
+		if atomic.AddInt32(&hits, 1) <= 10 {
+			w.WriteHeader(500)
+			return
 		}
 		w.WriteHeader(200)
 	}))
 	defer ts.Close()
 
-	client, err := New(&Config{})
+	client, err := New(&Config{
+		RetryMax: 10,
+		RequestModifier: func(req *Request) *Request {
+			req.Header.Add("X-Attempt", "1")
+			return req
+		},
+	})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
-	// Make the request.
-	resp, err := client.Get(ts.URL + "/foo/bar")
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	req, err := NewRequest("PUT", ts.URL, []byte("hello"))
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	resp.Body.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if req.Body != nil || len(req.Header) != 0 {
+		t.Fatalf("expected the request to be left untouched, got body %v and header %v", req.Body, req.Header)
+	}
 }
 
-func TestClient_RequestLogHook(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			t.Fatalf("bad method: %s", r.Method)
-		}
-		if r.RequestURI != "/foo/bar" {
-			t.Fatalf("bad uri: %s", r.RequestURI)
+func TestClient_Do_sharedFileRequest(t *testing.T) {
+	f, err := ioutil.TempFile("", "retryablehttp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("hello"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+		if string(body) != "hello" {
+			t.Errorf("bad body: %q", body)
+		}
+		if atomic.AddInt32(&hits, 1) <= 10 {
+			w.WriteHeader(500)
+			return
 		}
 		w.WriteHeader(200)
 	}))
 	defer ts.Close()
 
-	retries := -1
-	testURIPath := "/foo/bar"
-
-	client, err := New(&Config{})
+	client, err := New(&Config{RetryMax: 10})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
-	client.RequestLogHook = func(logger Logger, req *http.Request, retry int) {
-		retries = retry
-
-		if logger != client.Logger {
-			t.Fatalf("Client logger was not passed to logging hook")
-		}
-
-		dumpBytes, err := httputil.DumpRequestOut(req, false)
-		if err != nil {
-			t.Fatal("Dumping requests failed")
-		}
-
-		dumpString := string(dumpBytes)
-		if !strings.Contains(dumpString, "GET "+testURIPath) {
-			t.Fatalf("Bad request dump:\n%s", dumpString)
-		}
-	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
 
-	// Make the request.
-	resp, err := client.Get(ts.URL + testURIPath)
+	req, err := NewRequest("PUT", ts.URL, f)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	resp.Body.Close()
+	req.KeepBodyOpen()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
 
-	if retries < 0 {
-		t.Fatal("Logging hook was not called")
+	if err := req.Close(); err != nil {
+		t.Fatalf("expected the file to be left open by Do, got: %v", err)
 	}
 }
 
-func TestClient_ResponseLogHook(t *testing.T) {
-	passAfter := time.Now().Add(100 * time.Millisecond)
+func TestClient_Do_onBodyRewind(t *testing.T) {
+	var hits int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if time.Now().After(passAfter) {
-			w.WriteHeader(200)
-			w.Write([]byte("test_200_body"))
-		} else {
+		if atomic.AddInt32(&hits, 1) < 3 {
 			w.WriteHeader(500)
-			w.Write([]byte("test_500_body"))
+			return
 		}
+		w.WriteHeader(200)
 	}))
 	defer ts.Close()
 
-	buf := new(bytes.Buffer)
-
-	client, err := New(&Config{})
+	var rewinds []int
+	client, err := New(&Config{
+		OnBodyRewind: func(attempt int) {
+			rewinds = append(rewinds, attempt)
+		},
+	})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
-	client.Logger = nlogger.New(buf, "[HTTP]")
-	client.RetryWaitMin = 10 * time.Millisecond
-	client.RetryWaitMax = 10 * time.Millisecond
-	client.RetryMax = 15
-	client.ResponseLogHook = func(logger Logger, resp *http.Response) {
-		if resp.StatusCode == 200 {
-			// Log something when we get a 200
-			logger.Info("test_log_pass")
-		} else {
-			// Log the response body when we get a 500
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatalf("err: %v", err)
-			}
-			logger.Info(fmt.Sprintf("%s", body))
-		}
-	}
-
-	// Perform the request. Exits when we finally get a 200.
-	resp, err := client.Get(ts.URL)
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
 
-	// Make sure we can read the response body still, since we did not
-	// read or close it from the response log hook.
-	body, err := ioutil.ReadAll(resp.Body)
+	resp, err := client.Post(ts.URL, "text/plain", []byte("hello"))
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	if string(body) != "test_200_body" {
-		t.Fatalf("expect %q, got %q", "test_200_body", string(body))
-	}
+	resp.Body.Close()
 
-	// Make sure we wrote to the logger on callbacks.
-	out := buf.String()
-	if !strings.Contains(out, "test_log_pass") {
-		t.Fatalf("expect response callback on 200: %q", out)
-	}
-	if !strings.Contains(out, "test_500_body") {
-		t.Fatalf("expect response callback on 500: %q", out)
+	if len(rewinds) != 2 || rewinds[0] != 1 || rewinds[1] != 2 {
+		t.Fatalf("expected rewinds for attempts 1 and 2, got %v", rewinds)
 	}
 }
 
-func TestClient_RequestWithContext(t *testing.T) {
+func TestClient_Do_stripBodyOnMethods(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		w.Write([]byte("test_200_body"))
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+		w.Header().Set("X-Body-Length", strconv.Itoa(len(body)))
+		w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
 	}))
 	defer ts.Close()
 
-	req, err := NewRequest(http.MethodGet, ts.URL, nil)
-	if err != nil {
-		t.Fatalf("err: %v", err)
+	for _, strip := range []bool{false, true} {
+		config := &Config{}
+		if strip {
+			config.StripBodyOnMethods = []string{"GET", "HEAD", "DELETE"}
+		}
+		client, err := New(config)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+
+		req, err := NewRequest("GET", ts.URL, []byte("oops"))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp.Body.Close()
+
+		expect := "4"
+		if strip {
+			expect = "0"
+		}
+		if v := resp.Header.Get("X-Body-Length"); v != expect {
+			t.Fatalf("strip %v: expected body length %s, got %s", strip, expect, v)
+		}
+		if v := resp.Header.Get("X-Content-Length"); v != expect {
+			t.Fatalf("strip %v: expected Content-Length %s, got %s", strip, expect, v)
+		}
 	}
-	ctx, cancel := context.WithCancel(req.Request.Context())
-	req = req.WithContext(ctx)
+}
+
+func TestClient_Do_fails(t *testing.T) {
+	// Mock server which always responds 500.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
 
+	// Create the client. Use short retry windows so we fail faster.
 	client, err := New(&Config{})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+	client.RetryMax = 2
 
-	called := 0
-	client.CheckRetry = func(_ context.Context, resp *http.Response, err error) (bool, error) {
-		called++
-		return DefaultRetryPolicy(req.Request.Context(), resp, err)
+	// Create the request
+	req, err := NewRequest("POST", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
 	}
 
-	cancel()
+	// Send the request.
 	_, err = client.Do(req)
-
-	if called != 1 {
-		t.Fatalf("CheckRetry called %d times, expected 1", called)
-	}
-
-	if err != context.Canceled {
-		t.Fatalf("Expected context.Canceled err, got: %v", err)
+	if err == nil || !strings.Contains(err.Error(), "giving up") {
+		t.Fatalf("expected giving up error, got: %#v", err)
 	}
 }
 
-func TestClient_CheckRetry(t *testing.T) {
+func TestClient_Do_redirectLoop(t *testing.T) {
+	var hits int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "test_500_body", http.StatusInternalServerError)
+		atomic.AddInt32(&hits, 1)
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
 	}))
 	defer ts.Close()
 
-	client, err := New(&Config{})
+	client, err := New(&Config{MaxRedirects: 3})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
 
-	retryErr := errors.New("retryError")
-	called := 0
-	client.CheckRetry = func(_ context.Context, resp *http.Response, err error) (bool, error) {
-		if called < 1 {
-			called++
-			return DefaultRetryPolicy(context.TODO(), resp, err)
-		}
-
-		return false, retryErr
-	}
-
-	// CheckRetry should return our retryErr value and stop the retry loop.
-	_, err = client.Get(ts.URL)
-
-	if called != 1 {
-		t.Fatalf("CheckRetry called %d times, expected 1", called)
+	_, err = client.Get(ts.URL + "/loop")
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("expected too many redirects error, got: %v", err)
 	}
 
-	if err != retryErr {
-		t.Fatalf("Expected retryError, got:%v", err)
+	// A single attempt follows the redirects; no retries are made.
+	if hits != 3 {
+		t.Fatalf("expected 3 requests, got: %d", hits)
 	}
 }
 
-func TestClient_CheckRetryStop(t *testing.T) {
+func TestClient_MaxRedirects_sharedHttpClient(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "test_500_body", http.StatusInternalServerError)
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
 	}))
 	defer ts.Close()
 
-	client, err := New(&Config{})
+	client, err := New(&Config{HttpClient: http.DefaultClient, MaxRedirects: 3, RetryMax: 1})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
-
-	// Verify that this stops retries on the first try, with no errors from the client.
-	called := 0
-	client.CheckRetry = func(_ context.Context, resp *http.Response, err error) (bool, error) {
-		called++
-		return false, nil
-	}
-
-	_, err = client.Get(ts.URL)
-
-	if called != 1 {
-		t.Fatalf("CheckRetry called %d times, expeted 1", called)
+	_, err = client.Get(ts.URL + "/loop")
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("expected too many redirects error, got: %v", err)
 	}
-
-	if err != nil {
-		t.Fatalf("Expected no error, got:%v", err)
+	if http.DefaultClient.CheckRedirect != nil {
+		t.Fatalf("expected http.DefaultClient to be left untouched")
 	}
 }
 
-func TestClient_Head(t *testing.T) {
-	// Mock server which always responds 200.
+func TestClient_DoWithAttempts(t *testing.T) {
+	var hits int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "HEAD" {
-			t.Fatalf("bad method: %s", r.Method)
-		}
-		if r.RequestURI != "/foo/bar" {
-			t.Fatalf("bad uri: %s", r.RequestURI)
+		if atomic.AddInt32(&hits, 1) < 4 {
+			w.WriteHeader(503)
+			return
 		}
 		w.WriteHeader(200)
 	}))
 	defer ts.Close()
 
-	client, err := New(&Config{})
+	client, err := New(&Config{Backoff: NoBackoff})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
 
-	// Make the request.
-	resp, err := client.Head(ts.URL + "/foo/bar")
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, attempts, err := client.DoWithAttempts(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if attempts != 4 || hits != 4 {
+		t.Fatalf("expected 4 attempts, got %d for %d requests", attempts, hits)
+	}
+
+	// Without retries, the count is one.
+	resp, attempts, err = client.DoWithAttempts(req)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
 	resp.Body.Close()
+	if attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", attempts)
+	}
 }
 
-func TestClient_Post(t *testing.T) {
-	// Mock server which always responds 200.
+func TestClient_Do_disableRetries(t *testing.T) {
+	var hits int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			t.Fatalf("bad method: %s", r.Method)
-		}
-		if r.RequestURI != "/foo/bar" {
-			t.Fatalf("bad uri: %s", r.RequestURI)
-		}
-		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
-			t.Fatalf("bad content-type: %s", ct)
-		}
-
-		// Check the payload
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			t.Fatalf("err: %s", err)
-		}
-		expected := []byte(`{"hello":"world"}`)
-		if !bytes.Equal(body, expected) {
-			t.Fatalf("bad: %v", body)
-		}
-
-		w.WriteHeader(200)
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(503)
 	}))
 	defer ts.Close()
 
@@ -540,22 +989,1473 @@ func TestClient_Post(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+	client.CheckRetry = func(_ context.Context, resp *http.Response, err error) (bool, error) {
+		return true, nil
+	}
 
-	// Make the request.
-	resp, err := client.Post(
-		ts.URL+"/foo/bar",
-		"application/json",
-		strings.NewReader(`{"hello":"world"}`))
+	req, err := NewRequest("POST", ts.URL, []byte("pay"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req.DisableRetries()
+
+	// The response is passed through as-is.
+	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
 	resp.Body.Close()
+	if resp.StatusCode != 503 {
+		t.Fatalf("expected the 503 to be returned, got %d", resp.StatusCode)
+	}
+	if hits != 1 {
+		t.Fatalf("expected exactly 1 request, got: %d", hits)
+	}
 }
 
-func TestClient_PostForm(t *testing.T) {
-	// Mock server which always responds 200.
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
+func TestClient_Config_defaults(t *testing.T) {
+	client, err := New(&Config{RetryMax: 3})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if client.RetryMax != 3 {
+		t.Fatalf("expected RetryMax 3, got %d", client.RetryMax)
+	}
+	if client.RetryWaitMin != defaultRetryWaitMin {
+		t.Fatalf("expected RetryWaitMin %s, got %s", defaultRetryWaitMin, client.RetryWaitMin)
+	}
+	if client.RetryWaitMax != defaultRetryWaitMax {
+		t.Fatalf("expected RetryWaitMax %s, got %s", defaultRetryWaitMax, client.RetryWaitMax)
+	}
+
+	// Set values are kept, whatever RetryMax is.
+	client, err = New(&Config{RetryWaitMin: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if client.RetryWaitMin != time.Millisecond {
+		t.Fatalf("expected RetryWaitMin 1ms, got %s", client.RetryWaitMin)
+	}
+}
+
+func TestClient_RetryMaxElapsedTime(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		RetryMax:            10,
+		RetryWaitMin:        40 * time.Millisecond,
+		RetryWaitMax:        40 * time.Millisecond,
+		RetryMaxElapsedTime: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	start := time.Now()
+	_, err = client.Get(ts.URL)
+	elapsed := time.Since(start)
+	if !errors.Is(err, ErrRetryTimeExhausted) {
+		t.Fatalf("expected ErrRetryTimeExhausted, got %#v", err)
+	}
+	// Waits of 40ms fit twice in the budget, and the last one is cut to
+	// end with it.
+	if n := atomic.LoadInt32(&hits); n < 3 || n > 4 {
+		t.Fatalf("expected 3 or 4 requests, got %d", n)
+	}
+	if elapsed > 200*time.Millisecond {
+		t.Fatalf("expected to give up after ~100ms, took %s", elapsed)
+	}
+}
+
+func TestClient_GiveUpHook(t *testing.T) {
+	var fail int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer ts.Close()
+
+	for _, withHandler := range []bool{false, true} {
+		var events []string
+		client, err := New(&Config{
+			RetryMax:     2,
+			RetryWaitMin: time.Millisecond,
+			RetryWaitMax: time.Millisecond,
+			GiveUpHook: func(req *http.Request, lastResp *http.Response, lastErr error, attempts int) {
+				if req.URL.String() != ts.URL {
+					t.Errorf("bad request URL: %s", req.URL)
+				}
+				if SafeStatusCode(lastResp) != 503 || lastErr != nil {
+					t.Errorf("expected the last 503, got %d, %v", SafeStatusCode(lastResp), lastErr)
+				}
+				events = append(events, fmt.Sprintf("give up after %d", attempts))
+			},
+		})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		if withHandler {
+			client.ErrorHandler = func(resp *http.Response, err error, numTries int) (*http.Response, error) {
+				events = append(events, "error handler")
+				return resp, err
+			}
+		}
+
+		atomic.StoreInt32(&fail, 1)
+		resp, _ := client.Get(ts.URL)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		expected := []string{"give up after 3"}
+		if withHandler {
+			expected = append(expected, "error handler")
+		}
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("expected %v, got %v", expected, events)
+		}
+
+		// Requests which succeed don't give up.
+		atomic.StoreInt32(&fail, 0)
+		resp, err = client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp.Body.Close()
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("expected no more events, got %v", events)
+		}
+	}
+}
+
+func TestClient_GiveUpHook_earlyReturns(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	var gaveUp []error
+	client, err := New(&Config{
+		RetryMax:     2,
+		RetryWaitMin: time.Hour,
+		RetryWaitMax: time.Hour,
+		GiveUpHook: func(req *http.Request, lastResp *http.Response, lastErr error, attempts int) {
+			if lastResp != nil || attempts != 1 {
+				t.Errorf("expected no response after 1 attempt, got %d after %d", SafeStatusCode(lastResp), attempts)
+			}
+			gaveUp = append(gaveUp, lastErr)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// The context is canceled during the backoff.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.Do(req.WithContext(ctx)); err != context.Canceled {
+		t.Fatalf("expected the context error, got: %v", err)
+	}
+	if len(gaveUp) != 1 || gaveUp[0] != context.Canceled {
+		t.Fatalf("expected the hook to get the context error, got %v", gaveUp)
+	}
+
+	// The body fails to rewind.
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	var reads int32
+	req, err = NewRequest("POST", ts.URL, ReaderFunc(func() (io.Reader, error) {
+		if atomic.AddInt32(&reads, 1) > 2 {
+			return nil, errors.New("rewind failed")
+		}
+		return strings.NewReader("body"), nil
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Fatalf("expected the rewind error")
+	}
+	if len(gaveUp) != 2 || gaveUp[1] == nil || gaveUp[1].Error() != "rewind failed" {
+		t.Fatalf("expected the hook to get the rewind error, got %v", gaveUp)
+	}
+}
+
+func TestClient_withoutNew(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer ts.Close()
+
+	// Clients embedded in mocks are often built without New.
+	client := &Client{Config: &Config{
+		Logger:       NopLogger{},
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+	}}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp.Body.Close()
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+
+	// Clearing the logger once set up doesn't panic either.
+	client.Logger = nil
+	if _, err := client.Get("ftp://example.com"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestClient_Config_DisableRetries(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{DisableRetries: true})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, attempts, err := client.DoWithAttempts(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 {
+		t.Fatalf("expected the 503 to be returned, got %d", resp.StatusCode)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected 1 round trip, got %d", attempts)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("expected exactly 1 request, got: %d", n)
+	}
+}
+
+func TestClient_CloseConnOnStatus(t *testing.T) {
+	for _, closeConn := range []bool{false, true} {
+		var hits, conns int32
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&hits, 1) == 1 {
+				w.WriteHeader(503)
+				return
+			}
+			w.WriteHeader(200)
+		}))
+		ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		ts.Start()
+
+		client, err := New(&Config{HttpClient: cleanhttp.DefaultPooledClient()})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+		client.CloseConnOnStatus = func(code int) bool {
+			return closeConn && code == 503
+		}
+
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		expect := int32(1)
+		if closeConn {
+			expect = 2
+		}
+		if conns != expect {
+			t.Fatalf("close %v: expected %d connections, got %d", closeConn, expect, conns)
+		}
+	}
+}
+
+func TestClient_CloseConnOnStatus_otherHosts(t *testing.T) {
+	var hits, otherConns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+	other := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	other.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&otherConns, 1)
+		}
+	}
+	other.Start()
+	defer other.Close()
+
+	client, err := New(&Config{HttpClient: cleanhttp.DefaultPooledClient()})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.CloseConnOnStatus = func(code int) bool { return code == 503 }
+
+	for _, url := range []string{other.URL, ts.URL, other.URL} {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if n := atomic.LoadInt32(&otherConns); n != 1 {
+		t.Fatalf("expected the idle connection to the other host to be kept, got %d connections", n)
+	}
+}
+
+func TestClient_DrainLargeErrorBody(t *testing.T) {
+	var hits, conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(503)
+			w.Write(bytes.Repeat([]byte("x"), 10<<10))
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	client, err := New(&Config{
+		HttpClient:         cleanhttp.DefaultPooledClient(),
+		ResponseDrainLimit: 64 << 10,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if c := atomic.LoadInt32(&conns); c != 1 {
+		t.Fatalf("expected the connection to be reused, got %d connections", c)
+	}
+}
+
+func TestClient_ReturnBestResponse(t *testing.T) {
+	var hits int32
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(502)
+			w.Write([]byte("bad gateway"))
+			return
+		}
+		// Then fail at the transport level.
+		ts.CloseClientConnections()
+	}))
+	defer ts.Close()
+
+	for _, enabled := range []bool{false, true} {
+		atomic.StoreInt32(&hits, 0)
+
+		client, err := New(&Config{ReturnBestResponse: enabled, RetryMax: 1})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+
+		resp, err := client.Get(ts.URL)
+		if !enabled {
+			if err == nil || !strings.Contains(err.Error(), "giving up") {
+				t.Fatalf("expected giving up error, got: %v", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp.StatusCode != 502 || string(body) != "bad gateway" {
+			t.Fatalf("expected the 502 to be returned, got %d %q", resp.StatusCode, body)
+		}
+	}
+}
+
+func TestClient_ReturnBestResponse_readError(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// Cut the body short.
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(502)
+			w.Write([]byte("bad"))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.WriteHeader(503)
+		w.Write([]byte("unavailable"))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{ReturnBestResponse: true, RetryMax: 1})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The truncated 502 was no candidate.
+	if resp.StatusCode != 503 || string(body) != "unavailable" {
+		t.Fatalf("expected the 503 to be returned, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestClient_ReturnLastResponse(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.WriteHeader([]int{500, 502, 503}[n-1])
+		fmt.Fprintf(w, "attempt %d", n)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{ReturnLastResponse: true, RetryMax: 2})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	var maxErr *MaxRetriesError
+	if !errors.As(err, &maxErr) {
+		t.Fatalf("expected a MaxRetriesError, got: %v", err)
+	}
+	if resp == nil {
+		t.Fatalf("expected the last response")
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.StatusCode != 503 || resp.StatusCode != maxErr.LastStatusCode {
+		t.Fatalf("expected the last attempt's 503, got %d", resp.StatusCode)
+	}
+	if string(body) != "attempt 3" {
+		t.Fatalf("expected the last attempt's body, got %q", body)
+	}
+}
+
+func TestClient_ReturnLastResponse_readError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Cut the body short.
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(503)
+		w.Write([]byte("unavail"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{ReturnLastResponse: true, RetryMax: 1})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	var maxErr *MaxRetriesError
+	if !errors.As(err, &maxErr) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a MaxRetriesError and the read error, got: %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("expected no truncated response, got %d", resp.StatusCode)
+	}
+}
+
+func TestClient_RequestModifiers(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	var order []string
+	client, err := New(&Config{
+		RequestModifier: func(req *Request) *Request {
+			order = append(order, "auth")
+			req.Header.Set("Authorization", "Bearer token")
+			return req
+		},
+		RequestModifiers: []RequestModifier{
+			func(req *Request) *Request {
+				order = append(order, "trace")
+				if req.Header.Get("Authorization") == "" {
+					t.Errorf("expected the output of RequestModifier")
+				}
+				req.Header.Set("X-Trace-Id", "abc")
+				return req
+			},
+			func(req *Request) *Request {
+				order = append(order, "last")
+				return req
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if !reflect.DeepEqual(order, []string{"auth", "trace", "last"}) {
+		t.Fatalf("expected modifiers in order, got %v", order)
+	}
+	if header.Get("Authorization") != "Bearer token" || header.Get("X-Trace-Id") != "abc" {
+		t.Fatalf("expected both headers, got %v", header)
+	}
+}
+
+func TestClient_Get(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("bad method: %s", r.Method)
+		}
+		if r.RequestURI != "/foo/bar" {
+			t.Fatalf("bad uri: %s", r.RequestURI)
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	// Make the request.
+	resp, err := client.Get(ts.URL + "/foo/bar")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClient_Do_logsBodyInfo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	buf := new(bytes.Buffer)
+	client, err := New(&Config{Logger: nlogger.New(buf, "[HTTP]")})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Post(ts.URL, "application/json", []byte(`{"hello":"world"}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	out := buf.String()
+	if !strings.Contains(out, "content_length=17") {
+		t.Fatalf("expected content_length in log: %q", out)
+	}
+	if !strings.Contains(out, "content_type=application/json") {
+		t.Fatalf("expected content_type in log: %q", out)
+	}
+
+	// Bodies of unknown length are logged as -1.
+	buf.Reset()
+	req, err := NewRequest("POST", ts.URL, ReaderFunc(func() (io.Reader, error) {
+		return &custReader{}, nil
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	out = buf.String()
+	if !strings.Contains(out, "content_length=-1") {
+		t.Fatalf("expected unknown content_length in log: %q", out)
+	}
+}
+
+func TestClient_GetConditional(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// Without an etag we get the full response.
+	resp, notModified, err := client.GetConditional(context.Background(), ts.URL, "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if notModified || resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got: %d (not modified: %v)", resp.StatusCode, notModified)
+	}
+
+	// With the returned etag the server reports no change.
+	resp, notModified, err = client.GetConditional(context.Background(), ts.URL, resp.Header.Get("ETag"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if !notModified || resp.StatusCode != 304 {
+		t.Fatalf("expected 304, got: %d (not modified: %v)", resp.StatusCode, notModified)
+	}
+}
+
+func TestClient_RequestLogHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("bad method: %s", r.Method)
+		}
+		if r.RequestURI != "/foo/bar" {
+			t.Fatalf("bad uri: %s", r.RequestURI)
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	retries := -1
+	testURIPath := "/foo/bar"
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RequestLogHook = func(logger Logger, req *http.Request, retry int) {
+		retries = retry
+
+		if logger != client.Logger {
+			t.Fatalf("Client logger was not passed to logging hook")
+		}
+
+		dumpBytes, err := httputil.DumpRequestOut(req, false)
+		if err != nil {
+			t.Fatal("Dumping requests failed")
+		}
+
+		dumpString := string(dumpBytes)
+		if !strings.Contains(dumpString, "GET "+testURIPath) {
+			t.Fatalf("Bad request dump:\n%s", dumpString)
+		}
+	}
+
+	// Make the request.
+	resp, err := client.Get(ts.URL + testURIPath)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if retries < 0 {
+		t.Fatal("Logging hook was not called")
+	}
+}
+
+func TestClient_ResponseLogHook(t *testing.T) {
+	passAfter := time.Now().Add(100 * time.Millisecond)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if time.Now().After(passAfter) {
+			w.WriteHeader(200)
+			w.Write([]byte("test_200_body"))
+		} else {
+			w.WriteHeader(500)
+			w.Write([]byte("test_500_body"))
+		}
+	}))
+	defer ts.Close()
+
+	buf := new(bytes.Buffer)
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.Logger = nlogger.New(buf, "[HTTP]")
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+	client.RetryMax = 15
+	client.ResponseLogHook = func(logger Logger, resp *http.Response) {
+		if resp.StatusCode == 200 {
+			// Log something when we get a 200
+			logger.Info("test_log_pass")
+		} else {
+			// Log the response body when we get a 500
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			logger.Info(fmt.Sprintf("%s", body))
+		}
+	}
+
+	// Perform the request. Exits when we finally get a 200.
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Make sure we can read the response body still, since we did not
+	// read or close it from the response log hook.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != "test_200_body" {
+		t.Fatalf("expect %q, got %q", "test_200_body", string(body))
+	}
+
+	// Make sure we wrote to the logger on callbacks.
+	out := buf.String()
+	if !strings.Contains(out, "test_log_pass") {
+		t.Fatalf("expect response callback on 200: %q", out)
+	}
+	if !strings.Contains(out, "test_500_body") {
+		t.Fatalf("expect response callback on 500: %q", out)
+	}
+}
+
+func TestClient_RequestWithContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte("test_200_body"))
+	}))
+	defer ts.Close()
+
+	req, err := NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ctx, cancel := context.WithCancel(req.Request.Context())
+	req = req.WithContext(ctx)
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	called := 0
+	client.CheckRetry = func(_ context.Context, resp *http.Response, err error) (bool, error) {
+		called++
+		return DefaultRetryPolicy(req.Request.Context(), resp, err)
+	}
+
+	cancel()
+	_, err = client.Do(req)
+
+	if called != 1 {
+		t.Fatalf("CheckRetry called %d times, expected 1", called)
+	}
+
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled err, got: %v", err)
+	}
+}
+
+func TestClient_CheckRetry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "test_500_body", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	retryErr := errors.New("retryError")
+	called := 0
+	client.CheckRetry = func(_ context.Context, resp *http.Response, err error) (bool, error) {
+		if called < 1 {
+			called++
+			return DefaultRetryPolicy(context.TODO(), resp, err)
+		}
+
+		return false, retryErr
+	}
+
+	// CheckRetry should return our retryErr value and stop the retry loop.
+	_, err = client.Get(ts.URL)
+
+	if called != 1 {
+		t.Fatalf("CheckRetry called %d times, expected 1", called)
+	}
+
+	if err != retryErr {
+		t.Fatalf("Expected retryError, got:%v", err)
+	}
+}
+
+func TestClient_NonRetryable5xx(t *testing.T) {
+	cases := []struct {
+		code      int
+		nonRetry  []int
+		expectHit int32
+	}{
+		{500, nil, 2},
+		{501, nil, 1},
+		{502, nil, 2},
+		{503, nil, 2},
+		{504, nil, 2},
+		{505, nil, 1},
+		{501, []int{}, 2},
+		{503, []int{503}, 1},
+	}
+
+	for _, tc := range cases {
+		var hits int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.WriteHeader(tc.code)
+		}))
+
+		client, err := New(&Config{NonRetryable5xx: tc.nonRetry})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+		client.RetryMax = 1
+		client.ErrorHandler = PassthroughErrorHandler
+
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%d: err: %v", tc.code, err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		if hits != tc.expectHit {
+			t.Fatalf("%d (non-retryable %v): expected %d requests, got %d",
+				tc.code, tc.nonRetry, tc.expectHit, hits)
+		}
+	}
+}
+
+func TestClient_Do_permanentRequestErrors(t *testing.T) {
+	for _, u := range []string{"ftp://example.com/foo", "http:///foo"} {
+		var attempts int
+		client, err := New(&Config{})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RequestLogHook = func(_ Logger, _ *http.Request, _ int) {
+			attempts++
+		}
+
+		req, err := NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		start := time.Now()
+		if _, err := client.Do(req); err == nil {
+			t.Fatalf("%s: expected error", u)
+		}
+		if attempts != 1 {
+			t.Fatalf("%s: expected 1 attempt, got %d", u, attempts)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("%s: expected an immediate error, took %s", u, elapsed)
+		}
+	}
+}
+
+func TestClient_Do_retries429(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("slow down"))
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		RetryWaitMin: 10 * time.Millisecond,
+		RetryWaitMax: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if resp.StatusCode != 200 || string(body) != "hello" {
+		t.Fatalf("expected the 200 payload, got %d %q", resp.StatusCode, body)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestSafeStatusCode(t *testing.T) {
+	if code := SafeStatusCode(nil); code != 0 {
+		t.Fatalf("expected 0 for nil response, got %d", code)
+	}
+	if code := SafeStatusCode(&http.Response{StatusCode: 503}); code != 503 {
+		t.Fatalf("expected 503, got %d", code)
+	}
+
+	// The default policy must not panic on a nil response without error.
+	retry, err := DefaultRetryPolicy(context.Background(), nil, nil)
+	if !retry || err != nil {
+		t.Fatalf("expected retry of nil response, got %v, %v", retry, err)
+	}
+}
+
+func TestRetryOnStatusCodes(t *testing.T) {
+	policy := RetryOnStatusCodes(408, 425)
+	for _, tc := range []struct {
+		code  int
+		retry bool
+	}{
+		{200, false},
+		{404, false},
+		{408, true},
+		{425, true},
+		{429, true},
+		{500, true},
+		{501, false},
+	} {
+		retry, err := policy(context.Background(), &http.Response{StatusCode: tc.code}, nil)
+		if err != nil {
+			t.Fatalf("%d: Err: %#v", tc.code, err)
+		}
+		if retry != tc.retry {
+			t.Fatalf("%d: expected retry %v, got %v", tc.code, tc.retry, retry)
+		}
+	}
+
+	// Connection errors are handled like DefaultRetryPolicy.
+	connErr := errors.New("connection reset")
+	if retry, err := policy(context.Background(), nil, connErr); !retry || err != connErr {
+		t.Fatalf("expected retry of connection error, got %v, %v", retry, err)
+	}
+
+	// The context is checked before the status codes.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	retry, err := policy(ctx, &http.Response{StatusCode: 408}, nil)
+	if retry || err != context.Canceled {
+		t.Fatalf("expected no retry on canceled context, got %v, %v", retry, err)
+	}
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusRequestTimeout)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		RetryWaitMin: 10 * time.Millisecond,
+		RetryWaitMax: 10 * time.Millisecond,
+		CheckRetry:   RetryOnStatusCodes(http.StatusRequestTimeout),
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://foo", Err: err}
+	}
+	cert := &x509.Certificate{}
+
+	cases := []struct {
+		name  string
+		err   error
+		retry bool
+	}{
+		{"timeout", wrap(&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}), true},
+		{"connection reset", wrap(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"temporary dns failure", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "server misbehaving", Name: "foo", IsTemporary: true}}), true},
+		{"unknown host", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "foo", IsNotFound: true}}), false},
+		{"unknown authority", wrap(x509.UnknownAuthorityError{Cert: cert}), false},
+		{"expired certificate", wrap(x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired}), false},
+		{"wrong host", wrap(x509.HostnameError{Certificate: cert, Host: "foo"}), false},
+		{"certificate verification", wrap(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{Cert: cert}}), false},
+		{"redirect loop", wrap(fmt.Errorf("stopped after 10 redirects: %w", ErrTooManyRedirects)), false},
+		{"unsupported scheme", wrap(errors.New("unsupported protocol scheme \"ftp\"")), false},
+	}
+	for _, tc := range cases {
+		if retry := IsRetryableError(tc.err); retry != tc.retry {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.retry, retry)
+		}
+	}
+
+	// An untrusted server certificate isn't retried.
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	var attempts int
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RequestLogHook = func(_ Logger, _ *http.Request, _ int) {
+		attempts++
+	}
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatalf("expected a certificate error")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestConnectionErrorRetryPolicy(t *testing.T) {
+	opErr := func(errno syscall.Errno) error {
+		return &url.Error{
+			Op:  "Get",
+			URL: "http://foo",
+			Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", errno)},
+		}
+	}
+
+	cases := []struct {
+		name  string
+		err   error
+		retry bool
+	}{
+		{"connection reset", opErr(syscall.ECONNRESET), true},
+		{"connection refused", opErr(syscall.ECONNREFUSED), true},
+		{"broken pipe", opErr(syscall.EPIPE), true},
+		{"other errno", opErr(syscall.EACCES), false},
+		{"not an OpError", &url.Error{Op: "Get", URL: "http://foo", Err: errors.New("unsupported protocol scheme")}, false},
+		{"redirect loop", ErrTooManyRedirects, false},
+	}
+
+	for _, tc := range cases {
+		retry, err := ConnectionErrorRetryPolicy(context.Background(), nil, tc.err)
+		if retry != tc.retry {
+			t.Fatalf("%s: expected retry %v, got %v", tc.name, tc.retry, retry)
+		}
+		if err != tc.err {
+			t.Fatalf("%s: expected error to be returned, got %v", tc.name, err)
+		}
+	}
+
+	// Non-idempotent requests are only retried when they weren't sent.
+	postCtx := context.WithValue(context.Background(), attemptInfoKey{}, &AttemptInfo{Method: "POST"})
+	if retry, _ := ConnectionErrorRetryPolicy(postCtx, nil, opErr(syscall.EPIPE)); retry {
+		t.Fatalf("expected no retry of a broken pipe on POST")
+	}
+	if retry, _ := ConnectionErrorRetryPolicy(postCtx, nil, opErr(syscall.ECONNRESET)); retry {
+		t.Fatalf("expected no retry of a connection reset on POST")
+	}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	if retry, _ := ConnectionErrorRetryPolicy(postCtx, nil, dialErr); !retry {
+		t.Fatalf("expected retry of a refused connection on POST")
+	}
+
+	// Responses are handled like the default policy.
+	if retry, _ := ConnectionErrorRetryPolicy(context.Background(), &http.Response{StatusCode: 503}, nil); !retry {
+		t.Fatalf("expected retry on 503")
+	}
+	if retry, _ := ConnectionErrorRetryPolicy(context.Background(), &http.Response{StatusCode: 404}, nil); retry {
+		t.Fatalf("expected no retry on 404")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClient_Do_brokenWrite(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		method string
+		hits   int32
+	}{
+		{"PUT", 2},
+		{"DELETE", 2},
+		{"POST", 1},
+		{"PATCH", 1},
+	} {
+		// The first attempt reads some of the body and then fails as if
+		// the server had closed the connection.
+		var hits int32
+		transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&hits, 1) == 1 {
+				r.Body.Read(make([]byte, 2))
+				return nil, &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+			}
+			return http.DefaultTransport.RoundTrip(r)
+		})
+		client, err := New(&Config{
+			HttpClient:   &http.Client{Transport: transport},
+			RetryWaitMin: time.Millisecond,
+			RetryWaitMax: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+
+		req, err := NewRequest(tc.method, ts.URL, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp, err := client.Do(req)
+		if n := atomic.LoadInt32(&hits); n != tc.hits {
+			t.Fatalf("%s: expected %d attempts, got %d", tc.method, tc.hits, n)
+		}
+		if tc.hits == 1 {
+			if !errors.Is(err, syscall.EPIPE) {
+				t.Fatalf("%s: expected a broken pipe, got %#v", tc.method, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Err: %#v", tc.method, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello" {
+			t.Fatalf("%s: expected the rewound body, got %q", tc.method, body)
+		}
+	}
+}
+
+func TestClient_RetryNonIdempotent(t *testing.T) {
+	// The server reads every request and drops the connection without
+	// answering.
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Err: %#v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		method    string
+		allow     bool
+		expectHit int32
+	}{
+		{"GET", false, 3},
+		{"PUT", false, 3},
+		{"POST", false, 1},
+		{"PATCH", false, 1},
+		{"POST", true, 3},
+	} {
+		atomic.StoreInt32(&hits, 0)
+		client, err := New(&Config{
+			RetryMax:           2,
+			RetryWaitMin:       time.Millisecond,
+			RetryWaitMax:       time.Millisecond,
+			RetryNonIdempotent: tc.allow,
+		})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		req, err := NewRequest(tc.method, ts.URL, strings.NewReader("charge"))
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		if _, err := client.Do(req); err == nil {
+			t.Fatalf("%s: expected error", tc.method)
+		}
+		if n := atomic.LoadInt32(&hits); n != tc.expectHit {
+			t.Fatalf("%s (allowed %v): expected %d requests, got %d", tc.method, tc.allow, tc.expectHit, n)
+		}
+	}
+
+	// Requests which never reached a server are retried whatever their
+	// method.
+	var attempts int
+	client, err := New(&Config{
+		RetryMax:     2,
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RequestLogHook = func(_ Logger, _ *http.Request, _ int) {
+		attempts++
+	}
+	addr := ts.Listener.Addr().String()
+	ts.Close()
+	req, err := NewRequest("POST", "http://"+addr, strings.NewReader("charge"))
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Fatalf("expected error")
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts of a refused POST, got %d", attempts)
+	}
+}
+
+func TestClient_NoRetryHeader(t *testing.T) {
+	cases := []struct {
+		configured string
+		header     string
+		value      string
+		expectHits int32
+	}{
+		{"", "X-No-Retry", "true", 3},
+		{"X-No-Retry", "X-No-Retry", "true", 1},
+		{"X-No-Retry", "X-No-Retry", "1", 1},
+		{"X-No-Retry", "X-No-Retry", "false", 3},
+		{"X-No-Retry", "X-Other", "true", 3},
+		{"X-Stop", "X-Stop", "true", 1},
+		{"X-Stop", "X-No-Retry", "true", 3},
+	}
+
+	for _, tc := range cases {
+		var hits int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Header().Set(tc.header, tc.value)
+			w.WriteHeader(503)
+		}))
+
+		client, err := New(&Config{NoRetryHeader: tc.configured, RetryMax: 2})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+
+		resp, err := client.Get(ts.URL)
+		ts.Close()
+		if tc.expectHits == 1 {
+			if err != nil {
+				t.Fatalf("%#v: err: %v", tc, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 503 {
+				t.Fatalf("%#v: expected the 503 to be returned, got %d", tc, resp.StatusCode)
+			}
+		}
+		if hits != tc.expectHits {
+			t.Fatalf("%#v: expected %d requests, got %d", tc, tc.expectHits, hits)
+		}
+	}
+}
+
+func TestClient_CheckRetryStop(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "test_500_body", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// Verify that this stops retries on the first try, with no errors from the client.
+	called := 0
+	client.CheckRetry = func(_ context.Context, resp *http.Response, err error) (bool, error) {
+		called++
+		return false, nil
+	}
+
+	_, err = client.Get(ts.URL)
+
+	if called != 1 {
+		t.Fatalf("CheckRetry called %d times, expeted 1", called)
+	}
+
+	if err != nil {
+		t.Fatalf("Expected no error, got:%v", err)
+	}
+}
+
+func TestClient_Head(t *testing.T) {
+	// Mock server which always responds 200.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Fatalf("bad method: %s", r.Method)
+		}
+		if r.RequestURI != "/foo/bar" {
+			t.Fatalf("bad uri: %s", r.RequestURI)
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// Make the request.
+	resp, err := client.Head(ts.URL + "/foo/bar")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClient_Post(t *testing.T) {
+	// Mock server which always responds 200.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("bad method: %s", r.Method)
+		}
+		if r.RequestURI != "/foo/bar" {
+			t.Fatalf("bad uri: %s", r.RequestURI)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("bad content-type: %s", ct)
+		}
+
+		// Check the payload
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		expected := []byte(`{"hello":"world"}`)
+		if !bytes.Equal(body, expected) {
+			t.Fatalf("bad: %v", body)
+		}
+
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// Make the request.
+	resp, err := client.Post(
+		ts.URL+"/foo/bar",
+		"application/json",
+		strings.NewReader(`{"hello":"world"}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClient_PostForm(t *testing.T) {
+	// Mock server which always responds 200.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
 			t.Fatalf("bad method: %s", r.Method)
 		}
 		if r.RequestURI != "/foo/bar" {
@@ -565,89 +2465,820 @@ func TestClient_PostForm(t *testing.T) {
 			t.Fatalf("bad content-type: %s", ct)
 		}
 
-		// Check the payload
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			t.Fatalf("err: %s", err)
-		}
-		expected := []byte(`hello=world`)
-		if !bytes.Equal(body, expected) {
-			t.Fatalf("bad: %v", body)
+		// Check the payload
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		expected := []byte(`hello=world`)
+		if !bytes.Equal(body, expected) {
+			t.Fatalf("bad: %v", body)
+		}
+
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	// Create the form data.
+	form, err := url.ParseQuery("hello=world")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// Make the request.
+	resp, err := client.PostForm(ts.URL+"/foo/bar", form)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewPost(t *testing.T) {
+	req, err := NewPost("http://example.com/foo", "application/json", []byte(`{"hello":"world"}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if req.Method != "POST" {
+		t.Fatalf("bad method: %s", req.Method)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("bad content-type: %s", ct)
+	}
+
+	// The body can be read more than once, as for retries.
+	for i := 0; i < 2; i++ {
+		body, err := req.body()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(b) != `{"hello":"world"}` {
+			t.Fatalf("bad body: %q", b)
+		}
+	}
+}
+
+func TestClient_PutPatchDelete(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Content-Type", r.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	cases := []struct {
+		do     func() (*http.Response, error)
+		method string
+		ct     string
+		body   string
+	}{
+		{func() (*http.Response, error) { return client.Put(ts.URL, "application/json", []byte(`{"a":1}`)) }, "PUT", "application/json", `{"a":1}`},
+		{func() (*http.Response, error) { return client.Patch(ts.URL, "text/plain", strings.NewReader("patch")) }, "PATCH", "text/plain", "patch"},
+		{func() (*http.Response, error) { return client.Delete(ts.URL) }, "DELETE", "", ""},
+	}
+	for _, tc := range cases {
+		resp, err := tc.do()
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.method, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.method, err)
+		}
+		if m := resp.Header.Get("X-Method"); m != tc.method {
+			t.Fatalf("%s: bad method: %s", tc.method, m)
+		}
+		if ct := resp.Header.Get("X-Content-Type"); ct != tc.ct {
+			t.Fatalf("%s: bad content-type: %s", tc.method, ct)
+		}
+		if string(body) != tc.body {
+			t.Fatalf("%s: bad body: %q", tc.method, body)
+		}
+	}
+}
+
+func TestNewPostForm(t *testing.T) {
+	form, err := url.ParseQuery("hello=world")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req, err := NewPostForm("http://example.com/foo", form)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+		t.Fatalf("bad content-type: %s", ct)
+	}
+	body, err := req.body()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(b) != "hello=world" {
+		t.Fatalf("bad body: %q", b)
+	}
+}
+
+func TestNewGetHead(t *testing.T) {
+	get, err := NewGet("http://example.com/foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	head, err := NewHead("http://example.com/foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if get.Method != "GET" || head.Method != "HEAD" {
+		t.Fatalf("bad methods: %s, %s", get.Method, head.Method)
+	}
+	if get.body != nil || head.body != nil {
+		t.Fatalf("expected no body")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	type tcase struct {
+		min    time.Duration
+		max    time.Duration
+		i      int
+		expect time.Duration
+	}
+	cases := []tcase{
+		{
+			time.Second,
+			5 * time.Minute,
+			0,
+			time.Second,
+		},
+		{
+			time.Second,
+			5 * time.Minute,
+			1,
+			2 * time.Second,
+		},
+		{
+			time.Second,
+			5 * time.Minute,
+			2,
+			4 * time.Second,
+		},
+		{
+			time.Second,
+			5 * time.Minute,
+			3,
+			8 * time.Second,
+		},
+		{
+			time.Second,
+			5 * time.Minute,
+			63,
+			5 * time.Minute,
+		},
+		{
+			time.Second,
+			5 * time.Minute,
+			128,
+			5 * time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		if v := DefaultBackoff(tc.min, tc.max, tc.i, nil); v != tc.expect {
+			t.Fatalf("bad: %#v -> %s", tc, v)
+		}
+	}
+}
+
+func TestConfigurableExponentialBackoff(t *testing.T) {
+	cases := []struct {
+		multiplier float64
+		expect     []time.Duration
+	}{
+		{
+			1.5,
+			[]time.Duration{
+				time.Second,
+				1500 * time.Millisecond,
+				2250 * time.Millisecond,
+				3375 * time.Millisecond,
+				5 * time.Second,
+			},
+		},
+		{
+			2.0,
+			[]time.Duration{
+				time.Second,
+				2 * time.Second,
+				4 * time.Second,
+				5 * time.Second,
+				5 * time.Second,
+			},
+		},
+		{
+			3.0,
+			[]time.Duration{
+				time.Second,
+				3 * time.Second,
+				5 * time.Second,
+				5 * time.Second,
+				5 * time.Second,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		backoff := ConfigurableExponentialBackoff(tc.multiplier)
+		for i, expect := range tc.expect {
+			if v := backoff(time.Second, 5*time.Second, i, nil); v != expect {
+				t.Fatalf("multiplier %v, attempt %d: expected %s, got %s", tc.multiplier, i, expect, v)
+			}
+		}
+	}
+}
+
+func TestClient_BackoffMultiplier(t *testing.T) {
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if client.BackoffMultiplier != 2.0 {
+		t.Fatalf("expected default multiplier of 2.0, got: %v", client.BackoffMultiplier)
+	}
+
+	client, err = New(&Config{BackoffMultiplier: 3.0})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if v := client.Backoff(time.Second, time.Minute, 2, nil); v != 9*time.Second {
+		t.Fatalf("expected 9s, got: %s", v)
+	}
+}
+
+func TestRateLimitHeaderBackoff(t *testing.T) {
+	rateLimited := func(code int, remaining string, reset time.Time) *http.Response {
+		resp := &http.Response{StatusCode: code, Header: http.Header{}}
+		resp.Header.Set("X-RateLimit-Remaining", remaining)
+		resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		return resp
+	}
+	now := time.Now()
+
+	// Waits until the reset time on an exhausted rate limit.
+	for _, code := range []int{403, 429} {
+		v := RateLimitHeaderBackoff(time.Second, time.Minute, 0, rateLimited(code, "0", now.Add(10*time.Second)))
+		if v < 8*time.Second || v > 10*time.Second {
+			t.Fatalf("%d: expected wait until reset, got %s", code, v)
+		}
+	}
+
+	// The wait is clamped to max and floored at min.
+	if v := RateLimitHeaderBackoff(time.Second, time.Minute, 0, rateLimited(429, "0", now.Add(time.Hour))); v != time.Minute {
+		t.Fatalf("expected max wait, got %s", v)
+	}
+	if v := RateLimitHeaderBackoff(time.Second, time.Minute, 0, rateLimited(429, "0", now.Add(-time.Hour))); v != time.Second {
+		t.Fatalf("expected min wait, got %s", v)
+	}
+
+	// Otherwise exponential backoff applies.
+	cases := []*http.Response{
+		nil,
+		rateLimited(429, "5", now.Add(time.Hour)),
+		rateLimited(500, "0", now.Add(time.Hour)),
+		{StatusCode: 429, Header: http.Header{"X-Ratelimit-Remaining": []string{"0"}}},
+	}
+	for _, resp := range cases {
+		if v := RateLimitHeaderBackoff(time.Second, time.Minute, 2, resp); v != 4*time.Second {
+			t.Fatalf("expected exponential backoff for %#v, got %s", resp, v)
+		}
+	}
+}
+
+func TestClient_Schedule(t *testing.T) {
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	expect := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	schedule := client.Schedule(nil)
+	if len(schedule) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, schedule)
+	}
+	for i := range expect {
+		if schedule[i] != expect[i] {
+			t.Fatalf("expected %v, got %v", expect, schedule)
 		}
+	}
 
-		w.WriteHeader(200)
+	// Waits are capped by RetryWaitMax.
+	client.RetryMax = 8
+	schedule = client.Schedule(nil)
+	if last := schedule[len(schedule)-1]; last != 30*time.Second {
+		t.Fatalf("expected schedule capped at 30s, got %v", schedule)
+	}
+}
+
+func TestClient_Schedule_withoutNew(t *testing.T) {
+	client := &Client{Config: &Config{RetryMax: 2}}
+
+	// The defaults New would apply are used.
+	expect := []time.Duration{time.Second, 2 * time.Second}
+	schedule := client.Schedule(nil)
+	if len(schedule) != len(expect) || schedule[0] != expect[0] || schedule[1] != expect[1] {
+		t.Fatalf("expected %v, got %v", expect, schedule)
+	}
+}
+
+func TestClient_WaitInterruptedByCancel(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(503)
 	}))
 	defer ts.Close()
 
-	// Create the form data.
-	form, err := url.ParseQuery("hello=world")
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = 30 * time.Second
+	client.RetryWaitMax = 30 * time.Second
+
+	var rewinds int32
+	req, err := NewRequest("POST", ts.URL, func() (io.Reader, error) {
+		atomic.AddInt32(&rewinds, 1)
+		return strings.NewReader("hello"), nil
+	})
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
+	// NewRequest reads the body once to find its length.
+	atomic.StoreInt32(&rewinds, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = client.Do(req.WithContext(ctx))
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the wait to be interrupted, took %s", elapsed)
+	}
+	if hits != 1 {
+		t.Fatalf("expected 1 request, got %d", hits)
+	}
+	if rewinds != 1 {
+		t.Fatalf("expected the body to be read once, got %d", rewinds)
+	}
+}
+
+func TestClient_BackoffCappedByDeadline(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
 
 	client, err := New(&Config{})
 	if err != nil {
 		t.Fatalf("Err: %#v", err)
 	}
+	client.Backoff = func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		return 10 * time.Second
+	}
 
-	// Make the request.
-	resp, err := client.PostForm(ts.URL+"/foo/bar", form)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req, err := NewRequest("GET", ts.URL, nil)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	resp.Body.Close()
-}
 
-func TestBackoff(t *testing.T) {
-	type tcase struct {
-		min    time.Duration
-		max    time.Duration
-		i      int
-		expect time.Duration
+	start := time.Now()
+	_, err = client.Do(req.WithContext(ctx))
+	if err == nil {
+		t.Fatalf("expected error")
 	}
-	cases := []tcase{
-		{
-			time.Second,
-			5 * time.Minute,
-			0,
-			time.Second,
-		},
-		{
-			time.Second,
-			5 * time.Minute,
-			1,
-			2 * time.Second,
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the backoff to be capped by the deadline, took %s", elapsed)
+	}
+	if hits > 2 {
+		t.Fatalf("expected at most 2 requests, got %d", hits)
+	}
+}
+
+func TestClient_NoTimeLeftKeepsBody(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	// The response arrives once the deadline has passed, leaving no time
+	// for a retry.
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		time.Sleep(time.Until(deadline))
+		return &http.Response{
+			StatusCode: 503,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("unavailable")),
+			Request:    r,
+		}, nil
+	})
+	client, err := New(&Config{
+		HttpClient: &http.Client{Transport: transport},
+		CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+			return true, nil
 		},
-		{
-			time.Second,
-			5 * time.Minute,
-			2,
-			4 * time.Second,
+		ErrorHandler: PassthroughErrorHandler,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != "unavailable" {
+		t.Fatalf("expected the body of the last response, got %q", body)
+	}
+}
+
+func TestClient_NoTimeLeftEarlyStop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		time.Sleep(time.Until(deadline))
+		return &http.Response{
+			StatusCode: 503,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("unavailable")),
+			Request:    r,
+		}, nil
+	})
+	client, err := New(&Config{
+		HttpClient: &http.Client{Transport: transport},
+		CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+			return true, nil
 		},
-		{
-			time.Second,
-			5 * time.Minute,
-			3,
-			8 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = client.Do(req.WithContext(ctx))
+	var stopErr *EarlyStopError
+	if !errors.As(err, &stopErr) || !errors.Is(err, ErrNoTimeLeft) {
+		t.Fatalf("expected an EarlyStopError for the deadline, got: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the error to match context.DeadlineExceeded, got: %v", err)
+	}
+	var maxErr *MaxRetriesError
+	if errors.As(err, &maxErr) {
+		t.Fatalf("expected no MaxRetriesError, got: %v", err)
+	}
+}
+
+func TestClient_RetryAfterCappedByDeadline(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{Backoff: RetryAfterBackoff})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMax = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	start := time.Now()
+	_, err = client.Do(req.WithContext(ctx))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up promptly, took %s", elapsed)
+	}
+	if hits != 1 {
+		t.Fatalf("expected 1 request, got %d", hits)
+	}
+}
+
+func TestClient_RetryAfterPastDeadlineKeepsBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(503)
+		w.Write([]byte("come back later"))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{ReturnLastResponse: true})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != "come back later" {
+		t.Fatalf("expected the body of the last response, got %q", body)
+	}
+}
+
+func TestRetryAfterBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	httpReq, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp := &http.Response{
+		StatusCode: 429,
+		Header:     http.Header{"Retry-After": []string{"60"}},
+		Request:    httpReq.WithContext(ctx),
+	}
+
+	if wait := RetryAfterBackoff(time.Second, time.Minute, 0, resp); wait > 2*time.Second || wait < time.Second {
+		t.Fatalf("expected wait capped by the deadline, got %s", wait)
+	}
+
+	resp.Request = httpReq
+	if wait := RetryAfterBackoff(time.Second, 30*time.Second, 0, resp); wait != 30*time.Second {
+		t.Fatalf("expected wait capped by max, got %s", wait)
+	}
+
+	resp.StatusCode = 500
+	if wait := RetryAfterBackoff(time.Second, time.Minute, 0, resp); wait != time.Second {
+		t.Fatalf("expected default backoff, got %s", wait)
+	}
+}
+
+func TestRetryAfterBackoff_headerForms(t *testing.T) {
+	resp := &http.Response{StatusCode: 503, Header: http.Header{}}
+
+	resp.Header.Set("Retry-After", "5")
+	if wait := RetryAfterBackoff(time.Second, time.Minute, 0, resp); wait != 5*time.Second {
+		t.Fatalf("expected 5s from delta-seconds, got %s", wait)
+	}
+
+	resp.Header.Set("Retry-After", time.Now().Add(10*time.Second).UTC().Format(http.TimeFormat))
+	if wait := RetryAfterBackoff(time.Second, time.Minute, 0, resp); wait < 8*time.Second || wait > 10*time.Second {
+		t.Fatalf("expected ~10s from HTTP-date, got %s", wait)
+	}
+
+	resp.Header.Set("Retry-After", "soon")
+	if wait := RetryAfterBackoff(time.Second, time.Minute, 2, resp); wait != 4*time.Second {
+		t.Fatalf("expected exponential fallback for unparseable header, got %s", wait)
+	}
+}
+
+func TestClient_RespectRetryAfterOverMax(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 503,
+		Header:     http.Header{"Retry-After": []string{"120"}},
+	}
+	cases := []struct {
+		respect bool
+		ceiling time.Duration
+		expect  time.Duration
+	}{
+		{false, 0, 30 * time.Second},
+		{true, 0, 120 * time.Second},
+		{true, time.Minute, time.Minute},
+	}
+	for _, tc := range cases {
+		client, err := New(&Config{
+			Backoff:                  RetryAfterBackoff,
+			RetryMax:                 1,
+			RetryWaitMax:             30 * time.Second,
+			RespectRetryAfterOverMax: tc.respect,
+			RetryAfterCeiling:        tc.ceiling,
+		})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		if wait := client.Schedule(resp)[0]; wait != tc.expect {
+			t.Fatalf("respect %v, ceiling %s: expected %s, got %s", tc.respect, tc.ceiling, tc.expect, wait)
+		}
+	}
+}
+
+func TestClient_RateLimitBackoff(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&hits, 1) {
+		case 1:
+			w.WriteHeader(429)
+		case 2:
+			w.WriteHeader(503)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	defer ts.Close()
+
+	var rateLimited, normal []int
+	client, err := New(&Config{
+		CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+			if SafeStatusCode(resp) == http.StatusTooManyRequests {
+				return true, nil
+			}
+			return DefaultRetryPolicy(ctx, resp, err)
 		},
-		{
-			time.Second,
-			5 * time.Minute,
-			63,
-			5 * time.Minute,
+		Backoff: func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+			normal = append(normal, resp.StatusCode)
+			return time.Millisecond
 		},
-		{
-			time.Second,
-			5 * time.Minute,
-			128,
-			5 * time.Minute,
+		RateLimitBackoff: func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+			rateLimited = append(rateLimited, resp.StatusCode)
+			return time.Millisecond
 		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
 	}
 
-	for _, tc := range cases {
-		if v := DefaultBackoff(tc.min, tc.max, tc.i, nil); v != tc.expect {
-			t.Fatalf("bad: %#v -> %s", tc, v)
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(rateLimited) != 1 || rateLimited[0] != 429 {
+		t.Fatalf("expected the rate limit backoff for the 429 only, got %v", rateLimited)
+	}
+	if len(normal) != 1 || normal[0] != 503 {
+		t.Fatalf("expected the normal backoff for the 503 only, got %v", normal)
+	}
+}
+
+func TestFullJitterBackoff(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		ceiling := time.Second << uint(i)
+		if ceiling > time.Minute {
+			ceiling = time.Minute
+		}
+		for j := 0; j < 100; j++ {
+			if v := FullJitterBackoff(time.Second, time.Minute, i, nil); v < 0 || v >= ceiling {
+				t.Fatalf("attempt %d: expected wait in [0, %s), got %s", i, ceiling, v)
+			}
+		}
+	}
+}
+
+func TestExponentialJitterBackoff(t *testing.T) {
+	const n = 1000
+	for i := 0; i < 10; i++ {
+		ceiling := time.Second << uint(i)
+		if ceiling > time.Minute {
+			ceiling = time.Minute
 		}
+		var sum, sumSq float64
+		for j := 0; j < n; j++ {
+			v := ExponentialJitterBackoff(time.Second, time.Minute, i, nil)
+			if v < ceiling/2 || v > ceiling {
+				t.Fatalf("attempt %d: expected wait in [%s, %s], got %s", i, ceiling/2, ceiling, v)
+			}
+			f := float64(v) / float64(ceiling)
+			sum += f
+			sumSq += f * f
+		}
+		// Uniform over [0.5, 1.0]: mean 0.75, variance 1/48.
+		mean := sum / n
+		variance := sumSq/n - mean*mean
+		if mean < 0.72 || mean > 0.78 {
+			t.Fatalf("attempt %d: expected a mean around 0.75, got %v", i, mean)
+		}
+		if variance < 0.015 || variance > 0.027 {
+			t.Fatalf("attempt %d: expected a variance around 0.021, got %v", i, variance)
+		}
+	}
+}
+
+func TestClient_BackoffJitter(t *testing.T) {
+	client, err := New(&Config{BackoffJitter: true, BackoffMultiplier: 3})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	waits := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		v := client.Backoff(time.Second, time.Hour, 2, nil)
+		if v < 4500*time.Millisecond || v > 9*time.Second {
+			t.Fatalf("expected wait in [4.5s, 9s], got %s", v)
+		}
+		waits[v] = true
+	}
+	if len(waits) < 10 {
+		t.Fatalf("expected jittered waits, got %v", waits)
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	min, max := 10*time.Millisecond, time.Second
+	backoff := NewDecorrelatedJitterBackoff()
+	for run := 0; run < 10; run++ {
+		prev := min
+		for i := 0; i < 20; i++ {
+			v := backoff(min, max, i, nil)
+			ceiling := 3 * prev
+			if i == 0 {
+				ceiling = 3 * min
+			}
+			if ceiling > max {
+				ceiling = max
+			}
+			if v < min || v > ceiling || v > max {
+				t.Fatalf("attempt %d: expected wait in [%s, %s], got %s", i, min, ceiling, v)
+			}
+			prev = v
+		}
+	}
+}
+
+func TestClient_NoBackoff(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{Backoff: NoBackoff})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Minute
+	client.RetryWaitMax = time.Minute
+
+	start := time.Now()
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatalf("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected retries back-to-back, took %s", elapsed)
+	}
+	if hits != int32(client.RetryMax+1) {
+		t.Fatalf("expected %d requests, got %d", client.RetryMax+1, hits)
 	}
 }
 
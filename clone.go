@@ -0,0 +1,47 @@
+package retryablehttp
+
+// Clone returns a new Client sharing c's configuration and metrics. It is
+// WithConfig without overrides.
+func (c *Client) Clone() *Client {
+	clone, _ := c.WithConfig(nil)
+	return clone
+}
+
+// WithConfig returns a new Client whose Config is a shallow copy of c's with
+// mutate, if not nil, applied to it. The copy shares HttpClient, Logger and
+// the other pointer fields with c, and the defaults New derived from c's
+// Config, such as CheckRetry and Backoff, are kept unless mutate replaces
+// them. The new Client reuses c's metrics collectors rather than
+// registering them again, but keeps its own counters and latency stats.
+func (c *Client) WithConfig(mutate func(*Config)) (*Client, error) {
+	if err := c.lazyInit(); err != nil {
+		return nil, err
+	}
+	cfg := *c.Config
+	if mutate != nil {
+		mutate(&cfg)
+	}
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+
+	metrics := c.metrics
+	if !cfg.Metrics || cfg.Lean {
+		metrics = nil
+	} else if metrics == nil {
+		var err error
+		if metrics, err = initMetrics(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	client := &Client{
+		Config:  &cfg,
+		metrics: metrics,
+	}
+	if cfg.MaxConcurrentRetries > 0 {
+		client.retrySlots = make(chan struct{}, cfg.MaxConcurrentRetries)
+	}
+	client.initOnce.Do(func() {})
+	return client, nil
+}
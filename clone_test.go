@@ -0,0 +1,100 @@
+package retryablehttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestClient_WithConfig(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	client, err := New(&Config{
+		Metrics:           true,
+		MetricsRegisterer: reg,
+		RetryMax:          3,
+		PerAttemptTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// Registering the collectors twice would fail.
+	clone, err := client.WithConfig(func(c *Config) {
+		c.RetryMax = 7
+		c.PerAttemptTimeout = 5 * time.Second
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	if clone.metrics == nil || clone.metrics != client.metrics {
+		t.Fatalf("expected the clone to share the metrics collectors")
+	}
+	if clone.HttpClient != client.HttpClient || clone.Logger != client.Logger {
+		t.Fatalf("expected the clone to share HttpClient and Logger")
+	}
+	if clone.RetryMax != 7 || clone.PerAttemptTimeout != 5*time.Second {
+		t.Fatalf("expected the overrides, got %d %s", clone.RetryMax, clone.PerAttemptTimeout)
+	}
+	if client.RetryMax != 3 || client.PerAttemptTimeout != time.Second {
+		t.Fatalf("expected the original config to be left alone, got %d %s", client.RetryMax, client.PerAttemptTimeout)
+	}
+}
+
+func TestClient_Clone(t *testing.T) {
+	client, err := New(&Config{RetryMax: 2})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	clone := client.Clone()
+	if clone.Config == client.Config {
+		t.Fatalf("expected the clone to have its own Config")
+	}
+	clone.RetryMax = 5
+	if client.RetryMax != 2 {
+		t.Fatalf("expected the original RetryMax, got %d", client.RetryMax)
+	}
+}
+
+func TestClient_WithConfig_MaxRedirects(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{MaxRedirects: 3, RetryMax: 1})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	clone, err := client.WithConfig(func(c *Config) {
+		c.MaxRedirects = 5
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	for _, tc := range []struct {
+		client *Client
+		hits   int32
+	}{
+		{clone, 5},
+		{client, 3},
+	} {
+		atomic.StoreInt32(&hits, 0)
+		_, err := tc.client.Get(ts.URL + "/loop")
+		if !errors.Is(err, ErrTooManyRedirects) {
+			t.Fatalf("expected too many redirects error, got: %v", err)
+		}
+		if n := atomic.LoadInt32(&hits); n != tc.hits {
+			t.Fatalf("expected %d requests, got %d", tc.hits, n)
+		}
+	}
+}
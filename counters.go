@@ -0,0 +1,39 @@
+package retryablehttp
+
+import "sync/atomic"
+
+// counters holds request totals for deployments which don't run Prometheus.
+type counters struct {
+	requests  atomic.Uint64
+	retries   atomic.Uint64
+	failures  atomic.Uint64
+	successes atomic.Uint64
+	recovered atomic.Uint64
+}
+
+// TotalRequests returns the number of Do calls made with the client.
+func (c *Client) TotalRequests() uint64 {
+	return c.counters.requests.Load()
+}
+
+// TotalRetries returns the number of retry attempts made by the client, not
+// counting the initial attempt of each request.
+func (c *Client) TotalRetries() uint64 {
+	return c.counters.retries.Load()
+}
+
+// TotalFailures returns the number of Do calls which returned an error.
+func (c *Client) TotalFailures() uint64 {
+	return c.counters.failures.Load()
+}
+
+// TotalSuccesses returns the number of Do calls which returned without error.
+func (c *Client) TotalSuccesses() uint64 {
+	return c.counters.successes.Load()
+}
+
+// TotalRecovered returns the number of Do calls which succeeded on a retry
+// after the initial attempt failed.
+func (c *Client) TotalRecovered() uint64 {
+	return c.counters.recovered.Load()
+}
@@ -0,0 +1,60 @@
+package retryablehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Counters(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if v := client.TotalRequests(); v != 1 {
+		t.Fatalf("expected 1 request, got %d", v)
+	}
+	if v := client.TotalRetries(); v != 2 {
+		t.Fatalf("expected 2 retries, got %d", v)
+	}
+	if v := client.TotalSuccesses(); v != 1 {
+		t.Fatalf("expected 1 success, got %d", v)
+	}
+	if v := client.TotalFailures(); v != 0 {
+		t.Fatalf("expected 0 failures, got %d", v)
+	}
+	if v := client.TotalRecovered(); v != 1 {
+		t.Fatalf("expected 1 recovered request, got %d", v)
+	}
+
+	// A request succeeding on its first attempt was not recovered.
+	resp, err = client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if v := client.TotalRecovered(); v != 1 {
+		t.Fatalf("expected 1 recovered request, got %d", v)
+	}
+}
@@ -0,0 +1,96 @@
+package retryablehttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// statusErrorBodyLimit is how much of a non-2xx response body DoJSON keeps
+// in its StatusError.
+const statusErrorBodyLimit = 1 << 10
+
+// DoDecode wraps calling Client.Do and, on a 2xx response, JSON-decodes the
+// response body into a value of type T. The body of a 2xx response is
+// consumed and closed before returning.
+//
+// Reading the body is bounded by the request context, so a deadline on it
+// also aborts a body which arrives too slowly.
+//
+// On a non-2xx response or a decode failure the zero value of T is returned
+// along with the response and an error. For non-2xx responses the error is
+// a *StatusError, and the body is left unread so the caller can inspect it;
+// it is up to the caller to close it.
+func DoDecode[T any](c *Client, req *Request) (T, *http.Response, error) {
+	var out T
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return out, resp, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return out, resp, newStatusError(req, resp)
+	}
+
+	body := contextBody(req.Context(), resp.Body)
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&out); err != nil {
+		var zero T
+		return zero, resp, err
+	}
+	return out, resp, nil
+}
+
+// DoJSON sends a request with in, if non-nil, marshaled to JSON as its body,
+// with retries, and decodes a 2xx response body into out, if non-nil. The
+// request accepts JSON responses. The response body is always consumed and
+// closed.
+//
+// On a non-2xx response the error is a *StatusError holding the start of
+// the response body.
+func (c *Client) DoJSON(ctx context.Context, method, url string, in, out interface{}) error {
+	var body interface{}
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = buf
+	}
+	req, err := NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := newStatusError(req, resp)
+		respBody := contextBody(ctx, resp.Body)
+		defer respBody.Close()
+		statusErr.Body, _ = ioutil.ReadAll(io.LimitReader(respBody, statusErrorBodyLimit))
+		return statusErr
+	}
+
+	respBody := contextBody(ctx, resp.Body)
+	if out == nil {
+		c.drainBody(respBody)
+		return nil
+	}
+	defer respBody.Close()
+	return json.NewDecoder(respBody).Decode(out)
+}
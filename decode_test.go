@@ -0,0 +1,237 @@
+package retryablehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoDecode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"foo","count":3}`))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	out, resp, err := DoDecode[payload](client, req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.StatusCode != 200 {
+		t.Fatalf("expected 200 response, got: %v", resp)
+	}
+	if out.Name != "foo" || out.Count != 3 {
+		t.Fatalf("bad decoded value: %#v", out)
+	}
+}
+
+func TestDoDecode_decodeFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`not json`))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, resp, err := DoDecode[map[string]string](client, req)
+	if err == nil {
+		t.Fatalf("expected decode error")
+	}
+	if resp == nil || resp.StatusCode != 200 {
+		t.Fatalf("expected response to be returned, got: %v", resp)
+	}
+	if out != nil {
+		t.Fatalf("expected zero value, got: %#v", out)
+	}
+}
+
+func TestDoDecode_non2xx(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte(`{"name":"foo"}`))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, resp, err := DoDecode[map[string]string](client, req)
+	if err == nil {
+		t.Fatalf("expected error on non-2xx")
+	}
+	if resp == nil || resp.StatusCode != 404 {
+		t.Fatalf("expected 404 response, got: %v", resp)
+	}
+	resp.Body.Close()
+	if out != nil {
+		t.Fatalf("expected zero value, got: %#v", out)
+	}
+}
+
+func TestDoDecode_slowBody(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":`))
+		w.(http.Flusher).Flush()
+		// Dribble the rest of the body far slower than the deadline.
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err = DoDecode[map[string]string](client, req.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("body read was not bounded by the deadline: %s", elapsed)
+	}
+}
+
+func TestClient_DoJSON(t *testing.T) {
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			t.Errorf("bad Accept: %q", r.Header.Get("Accept"))
+		}
+		switch r.URL.Path {
+		case "/echo":
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("bad Content-Type: %q", r.Header.Get("Content-Type"))
+			}
+			var in payload
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				t.Errorf("err: %v", err)
+			}
+			// Fail once to check the body is replayed.
+			if atomic.AddInt32(&hits, 1) == 1 {
+				w.WriteHeader(503)
+				return
+			}
+			in.Count++
+			json.NewEncoder(w).Encode(in)
+		case "/missing":
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"` + strings.Repeat("x", 2000) + `"}`))
+		}
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	var out payload
+	err = client.DoJSON(context.Background(), "POST", ts.URL+"/echo", payload{Name: "foo", Count: 1}, &out)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if out.Name != "foo" || out.Count != 2 {
+		t.Fatalf("bad response: %#v", out)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+
+	err = client.DoJSON(context.Background(), "GET", ts.URL+"/missing", nil, &out)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected a StatusError, got %#v", err)
+	}
+	if statusErr.StatusCode != 404 {
+		t.Fatalf("expected 404, got %d", statusErr.StatusCode)
+	}
+	if len(statusErr.Body) != statusErrorBodyLimit || !strings.HasPrefix(string(statusErr.Body), `{"error":"xxx`) {
+		t.Fatalf("expected the start of the body, got %q", statusErr.Body)
+	}
+}
+
+func TestClient_DoJSON_errorResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	body := &trackingBody{Reader: strings.NewReader("")}
+	client, err := New(&Config{
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+		ErrorHandler: func(resp *http.Response, err error, numTries int) (*http.Response, error) {
+			resp.Body.Close()
+			resp.Body = body
+			return resp, errors.New("gave up")
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	var out struct{}
+	if err := client.DoJSON(context.Background(), "GET", ts.URL, nil, &out); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !body.closed {
+		t.Fatalf("expected the response returned with the error to be closed")
+	}
+}
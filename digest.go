@@ -0,0 +1,112 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ErrDigestMismatch is wrapped by the error returned when a response body
+// doesn't match its Content-MD5 or Digest header and Config.VerifyDigest is
+// enabled.
+var ErrDigestMismatch = errors.New("response digest mismatch")
+
+// digestBodyLimit is the largest body verifyDigest holds in memory to
+// verify it. Longer bodies are handed back unverified.
+var digestBodyLimit int64 = 32 << 20
+
+// digestAlgorithms maps the Digest header algorithms we support to their
+// hash constructors.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha":     sha1.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// responseDigest returns the algorithm and expected base64 digest of resp,
+// preferring the Digest header over Content-MD5. It returns false when resp
+// carries no supported digest.
+func responseDigest(resp *http.Response) (string, string, bool) {
+	for _, part := range strings.Split(resp.Header.Get("Digest"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		alg := strings.ToLower(kv[0])
+		if _, ok := digestAlgorithms[alg]; ok {
+			return alg, kv[1], true
+		}
+	}
+	if v := resp.Header.Get("Content-MD5"); v != "" {
+		return "md5", v, true
+	}
+	return "", "", false
+}
+
+// digestApplies reports whether the body of resp is the full representation
+// its digest headers describe. It isn't for responses without a body, such
+// as those to HEAD requests or 304s, for partial content, and for bodies the
+// transport decompressed, as digests are computed over the encoded bytes.
+func digestApplies(resp *http.Response) bool {
+	if resp.Uncompressed {
+		return false
+	}
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	switch {
+	case resp.StatusCode < 200,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusPartialContent,
+		resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// verifyDigest reads the body of resp, hashing it on the way, and compares
+// the result with the digest advertised by the response. On success the
+// body is replaced with the bytes read. Bodies longer than digestBodyLimit
+// are handed back unverified.
+func verifyDigest(resp *http.Response) error {
+	if !digestApplies(resp) {
+		return nil
+	}
+	alg, expected, ok := responseDigest(resp)
+	if !ok {
+		return nil
+	}
+
+	h := digestAlgorithms[alg]()
+	buf := new(bytes.Buffer)
+	n, err := io.Copy(io.MultiWriter(buf, h), io.LimitReader(resp.Body, digestBodyLimit+1))
+	if err != nil {
+		return err
+	}
+	if n > digestBodyLimit {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(buf, resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(buf)
+
+	actual := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("%w: %s expected %s, got %s", ErrDigestMismatch, alg, expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_VerifyDigest(t *testing.T) {
+	body := []byte("integrity matters")
+	sha := sha256.Sum256(body)
+	md := md5.Sum(body)
+
+	cases := []struct {
+		header string
+		value  string
+	}{
+		{"Digest", "SHA-256=" + base64.StdEncoding.EncodeToString(sha[:])},
+		{"Content-MD5", base64.StdEncoding.EncodeToString(md[:])},
+	}
+
+	for _, tc := range cases {
+		var hits int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(tc.header, tc.value)
+			// Corrupt the first response.
+			if atomic.AddInt32(&hits, 1) == 1 {
+				w.Write([]byte("integrity mutters"))
+				return
+			}
+			w.Write(body)
+		}))
+
+		client, err := New(&Config{VerifyDigest: true})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.header, err)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		ts.Close()
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.header, err)
+		}
+		if string(got) != string(body) {
+			t.Fatalf("%s: bad body: %q", tc.header, got)
+		}
+		if hits != 2 {
+			t.Fatalf("%s: expected 2 requests, got %d", tc.header, hits)
+		}
+	}
+}
+
+func TestClient_VerifyDigest_mismatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-MD5", "bm90IHRoZSByaWdodCBvbmU=")
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{VerifyDigest: true, RetryMax: 1})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.ErrorHandler = PassthroughErrorHandler
+
+	_, err = client.Get(ts.URL)
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected digest mismatch, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "md5") {
+		t.Fatalf("expected algorithm in error, got: %v", err)
+	}
+}
+
+func TestClient_VerifyDigest_notApplicable(t *testing.T) {
+	const badDigest = "bm90IHRoZSByaWdodCBvbmU="
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	cases := []struct {
+		name    string
+		method  string
+		handler func(w http.ResponseWriter)
+	}{
+		{"head", "HEAD", func(w http.ResponseWriter) {
+			w.WriteHeader(200)
+		}},
+		{"not modified", "GET", func(w http.ResponseWriter) {
+			w.WriteHeader(304)
+		}},
+		{"partial content", "GET", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Range", "bytes 0-1/5")
+			w.WriteHeader(206)
+			w.Write([]byte("he"))
+		}},
+		{"decompressed", "GET", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipped.Bytes())
+		}},
+	}
+
+	for _, tc := range cases {
+		var hits int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Header().Set("Content-MD5", badDigest)
+			tc.handler(w)
+		}))
+
+		client, err := New(&Config{VerifyDigest: true, RetryMax: 1})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+
+		req, err := NewRequest(tc.method, ts.URL, nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.name, err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		ts.Close()
+		if hits != 1 {
+			t.Fatalf("%s: expected 1 request, got %d", tc.name, hits)
+		}
+	}
+}
+
+func TestClient_VerifyDigest_limit(t *testing.T) {
+	defer func(limit int64) { digestBodyLimit = limit }(digestBodyLimit)
+	digestBodyLimit = 4
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-MD5", "bm90IHRoZSByaWdodCBvbmU=")
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{VerifyDigest: true})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != "hello world" {
+		t.Fatalf("expected the body to be handed back whole, got %q", body)
+	}
+}
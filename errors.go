@@ -0,0 +1,81 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrRetryBudgetExhausted is wrapped by the error Do returns when it
+	// stops retrying because the retry budget of the request context, set
+	// with WithRetryBudget, is spent.
+	ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+	// ErrRetryTimeExhausted is wrapped by the error Do returns when it
+	// stops retrying because RetryMaxElapsedTime has passed since the
+	// first attempt.
+	ErrRetryTimeExhausted = errors.New("retry time exhausted")
+
+	// ErrRateLimited is wrapped by the error Do returns when it stops
+	// because the server asked, through Retry-After, to wait past the
+	// request's deadline, or because PerHostRateLimit would.
+	ErrRateLimited = errors.New("rate limited past the deadline")
+
+	// ErrNoTimeLeft is wrapped by the error Do returns when it stops
+	// because too little time is left before the request's deadline for
+	// another attempt. It wraps context.DeadlineExceeded.
+	ErrNoTimeLeft = fmt.Errorf("no time left for a retry: %w", context.DeadlineExceeded)
+)
+
+// MaxRetriesError is returned by Do when it gives up on a request after
+// exhausting its retries. It unwraps to the error of the last attempt, if
+// any.
+type MaxRetriesError struct {
+	Method   string
+	URL      string
+	Attempts int
+
+	// LastStatusCode and LastErr are the outcome of the last attempt.
+	// LastStatusCode is 0 when it failed without a response.
+	LastStatusCode int
+	LastErr        error
+
+	// Statuses holds the status code of each attempt in order, 0 for
+	// attempts which failed without a response.
+	Statuses []int
+}
+
+// MaxRetryError is the former name of MaxRetriesError.
+//
+// Deprecated: use MaxRetriesError.
+type MaxRetryError = MaxRetriesError
+
+func (e *MaxRetriesError) Error() string {
+	return fmt.Sprintf("%s %s giving up after %d attempts", e.Method, e.URL, e.Attempts)
+}
+
+func (e *MaxRetriesError) Unwrap() error {
+	return e.LastErr
+}
+
+// EarlyStopError is returned by Do when it stops before exhausting its
+// retries. Err tells why, and is one of ErrRetryBudgetExhausted,
+// ErrRetryTimeExhausted, ErrRateLimited, ErrNoTimeLeft or ErrCircuitOpen.
+type EarlyStopError struct {
+	Method string
+	URL    string
+
+	// Attempts is the number of attempts made before stopping, 0 when
+	// the CircuitBreaker didn't allow any.
+	Attempts int
+	Err      error
+}
+
+func (e *EarlyStopError) Error() string {
+	return fmt.Sprintf("%s %s stopped after %d attempts: %v", e.Method, e.URL, e.Attempts, e.Err)
+}
+
+func (e *EarlyStopError) Unwrap() error {
+	return e.Err
+}
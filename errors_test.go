@@ -0,0 +1,145 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_MaxRetriesError(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&hits, 1) {
+		case 1, 2:
+			w.WriteHeader(503)
+		case 3:
+			// Drop the connection without a response.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		default:
+			w.WriteHeader(502)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryMax = 3
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	_, err = client.Get(ts.URL)
+	var maxErr *MaxRetriesError
+	if !errors.As(err, &maxErr) {
+		t.Fatalf("expected a MaxRetriesError, got %v", err)
+	}
+
+	expected := []int{503, 503, 0, 502}
+	if len(maxErr.Statuses) != len(expected) {
+		t.Fatalf("expected statuses %v, got %v", expected, maxErr.Statuses)
+	}
+	for i := range expected {
+		if maxErr.Statuses[i] != expected[i] {
+			t.Fatalf("expected statuses %v, got %v", expected, maxErr.Statuses)
+		}
+	}
+	if maxErr.Attempts != 4 || maxErr.Method != "GET" || maxErr.URL != ts.URL ||
+		maxErr.LastStatusCode != 502 || maxErr.LastErr != nil {
+		t.Fatalf("bad error: %#v", maxErr)
+	}
+	if msg := "GET " + ts.URL + " giving up after 4 attempts"; err.Error() != msg {
+		t.Fatalf("expected %q, got %q", msg, err.Error())
+	}
+}
+
+func TestClient_MaxRetriesError_unwrap(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{Backoff: NoBackoff})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryMax = 1
+
+	_, err = client.Get(ts.URL)
+	var maxErr *MaxRetriesError
+	if !errors.As(err, &maxErr) {
+		t.Fatalf("expected a MaxRetriesError, got %v", err)
+	}
+	if maxErr.LastStatusCode != 0 {
+		t.Fatalf("expected no last status, got %d", maxErr.LastStatusCode)
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("expected the last transport error to be unwrapped, got %v", maxErr.LastErr)
+	}
+}
+
+func TestClient_EarlyStopError_rateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(429)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+			return SafeStatusCode(resp) == http.StatusTooManyRequests, err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = client.Do(req.WithContext(ctx))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	var stopErr *EarlyStopError
+	if !errors.As(err, &stopErr) || stopErr.Attempts != 1 {
+		t.Fatalf("expected to stop after 1 attempt, got %#v", stopErr)
+	}
+}
+
+func TestClient_EarlyStopError_circuitOpen(t *testing.T) {
+	client, err := New(&Config{CircuitBreaker: &testBreaker{open: true}})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	_, err = client.Get("http://127.0.0.1:1/foo")
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	var stopErr *EarlyStopError
+	if !errors.As(err, &stopErr) || stopErr.Attempts != 0 || stopErr.Method != "GET" {
+		t.Fatalf("expected to stop before any attempt, got %#v", stopErr)
+	}
+}
@@ -0,0 +1,72 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// GetAny sends a GET request to each of urls concurrently, each with the
+// full retry behavior of Do, and returns the first 2xx response. The other
+// requests are cancelled and their responses closed. It suits reading from
+// replicas, any of which is acceptable.
+//
+// If no request succeeds, the returned error joins the error of each
+// request, in the order of urls.
+func (c *Client) GetAny(ctx context.Context, urls []string) (*http.Response, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("GetAny: no URLs given")
+	}
+
+	type result struct {
+		i    int
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, len(urls))
+	cancels := make([]context.CancelFunc, len(urls))
+	for i, url := range urls {
+		reqCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		go func(i int, url string) {
+			req, err := NewRequest("GET", url, nil)
+			if err != nil {
+				results <- result{i: i, err: err}
+				return
+			}
+			resp, err := c.Do(req.WithContext(reqCtx))
+			results <- result{i: i, resp: resp, err: err}
+		}(i, url)
+	}
+
+	errs := make([]error, len(urls))
+	for n := 0; n < len(urls); n++ {
+		r := <-results
+		if r.err == nil && r.resp.StatusCode >= 200 && r.resp.StatusCode < 300 {
+			for i, cancel := range cancels {
+				if i != r.i {
+					cancel()
+				}
+			}
+			// Close the responses of the losers as they come in.
+			go func(left int) {
+				for ; left > 0; left-- {
+					if r := <-results; r.resp != nil {
+						r.resp.Body.Close()
+					}
+				}
+			}(len(urls) - n - 1)
+			r.resp.Body = &cancelBody{ReadCloser: r.resp.Body, cancel: cancels[r.i]}
+			return r.resp, nil
+		}
+
+		if r.err == nil {
+			c.drainBody(r.resp.Body)
+			r.err = fmt.Errorf("GET %s: unexpected status %s", urls[r.i], r.resp.Status)
+		}
+		cancels[r.i]()
+		errs[r.i] = r.err
+	}
+	return nil, errors.Join(errs...)
+}
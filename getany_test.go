@@ -0,0 +1,76 @@
+package retryablehttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_GetAny(t *testing.T) {
+	cancelled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("slow"))
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp, err := client.GetAny(context.Background(), []string{slow.URL, fast.URL})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if string(body) != "fast" {
+		t.Fatalf("expected the fast replica to win, got %q", body)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("slow request was not cancelled")
+	}
+}
+
+func TestClient_GetAny_allFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	_, err = client.GetAny(context.Background(), []string{ts.URL + "/a", "ftp://example.com/b"})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, want := range []string{"/a: unexpected status 404", "unsupported protocol scheme"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in %q", want, err)
+		}
+	}
+
+	if _, err := client.GetAny(context.Background(), nil); err == nil {
+		t.Fatalf("expected error without URLs")
+	}
+}
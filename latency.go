@@ -0,0 +1,88 @@
+package retryablehttp
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of buckets of a latencyHistogram, enough for
+// any positive time.Duration counted in microseconds.
+const latencyBuckets = 496
+
+// Stats summarizes the latency of the Do calls made with a Client.
+type Stats struct {
+	// Count is the number of Do calls observed.
+	Count uint64
+
+	// P50, P90 and P99 are latency percentiles, accurate to within
+	// 12.5%, or zero when Count is zero.
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// latencyHistogram counts durations in log-linear buckets: every power of
+// two microseconds is split in 8 buckets of equal width, which keeps the
+// relative error of a percentile bounded. The zero value is ready to use.
+type latencyHistogram struct {
+	count   atomic.Uint64
+	buckets [latencyBuckets]atomic.Uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.buckets[latencyBucket(uint64(d/time.Microsecond))].Add(1)
+	h.count.Add(1)
+}
+
+// percentile returns the upper bound of the bucket holding the q-th
+// quantile of the total observations.
+func (h *latencyHistogram) percentile(q float64, total uint64) time.Duration {
+	rank := uint64(q * float64(total))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i := range h.buckets {
+		seen += h.buckets[i].Load()
+		if seen >= rank {
+			return time.Duration(latencyUpperBound(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(latencyUpperBound(latencyBuckets-1)) * time.Microsecond
+}
+
+func latencyBucket(us uint64) int {
+	if us < 16 {
+		return int(us)
+	}
+	shift := bits.Len64(us) - 4
+	return shift*8 + int(us>>shift)
+}
+
+func latencyUpperBound(i int) uint64 {
+	if i < 16 {
+		return uint64(i)
+	}
+	shift := i/8 - 1
+	return (uint64(i%8+9) << shift) - 1
+}
+
+// LatencyStats returns percentiles of the duration of every Do call made
+// with the client, retries and backoff included. It is maintained
+// independently of Metrics, except in Lean mode, and is cheap to call.
+func (c *Client) LatencyStats() Stats {
+	total := c.latency.count.Load()
+	if total == 0 {
+		return Stats{}
+	}
+	return Stats{
+		Count: total,
+		P50:   c.latency.percentile(0.50, total),
+		P90:   c.latency.percentile(0.90, total),
+		P99:   c.latency.percentile(0.99, total),
+	}
+}
@@ -0,0 +1,67 @@
+package retryablehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	prev := -1
+	for us := uint64(0); us < 1<<20; us++ {
+		i := latencyBucket(us)
+		if i != prev && i != prev+1 {
+			t.Fatalf("%dus: bucket %d follows %d", us, i, prev)
+		}
+		if upper := latencyUpperBound(i); us > upper || float64(upper-us) > 0.125*float64(us) {
+			t.Fatalf("%dus: bucket %d has upper bound %d", us, i, upper)
+		}
+		prev = i
+	}
+	if i := latencyBucket(1<<64 - 1); i != latencyBuckets-1 {
+		t.Fatalf("expected the last bucket for the largest value, got %d", i)
+	}
+}
+
+func TestClient_LatencyStats(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// One slow request in ten.
+		if atomic.AddInt32(&hits, 1)%10 == 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if s := client.LatencyStats(); s != (Stats{}) {
+		t.Fatalf("expected empty stats, got %+v", s)
+	}
+
+	for i := 0; i < 20; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp.Body.Close()
+	}
+
+	s := client.LatencyStats()
+	if s.Count != 20 {
+		t.Fatalf("expected 20 requests, got %d", s.Count)
+	}
+	if s.P50 <= 0 || s.P50 > 50*time.Millisecond {
+		t.Fatalf("expected a fast median, got %s", s.P50)
+	}
+	if s.P99 < 100*time.Millisecond || s.P99 > time.Second {
+		t.Fatalf("expected a slow 99th percentile, got %s", s.P99)
+	}
+	if s.P50 > s.P90 || s.P90 > s.P99 {
+		t.Fatalf("percentiles out of order: %+v", s)
+	}
+}
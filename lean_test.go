@@ -0,0 +1,107 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lalamove/nui/nlogger"
+	"github.com/lalamove/nui/ntracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestClient_Lean(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	tracer := mocktracer.New()
+	prev := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(prev)
+
+	buf := new(bytes.Buffer)
+	client, err := New(&Config{
+		Lean:    true,
+		Metrics: true,
+		Logger:  nlogger.New(buf, "[HTTP]"),
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	parent := tracer.StartSpan("parent")
+	ctx := context.WithValue(context.Background(), ntracing.SpanKey, parent)
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || hits != 3 {
+		t.Fatalf("expected a 200 after 3 requests, got %d after %d", resp.StatusCode, hits)
+	}
+	if out := buf.String(); out != "" {
+		t.Fatalf("expected no logs, got %q", out)
+	}
+	if client.metrics != nil {
+		t.Fatalf("expected no metrics")
+	}
+	if spans := tracer.FinishedSpans(); len(spans) != 0 {
+		t.Fatalf("expected no spans, got %d", len(spans))
+	}
+	if stats := client.LatencyStats(); stats.Count != 0 {
+		t.Fatalf("expected no latency stats, got %d calls", stats.Count)
+	}
+	if _, when := client.LastStatus(req.URL.Host); !when.IsZero() {
+		t.Fatalf("expected no host status, got one from %v", when)
+	}
+}
+
+func benchmarkClientDo(b *testing.B, config *Config) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	config.Logger = nlogger.New(new(bytes.Buffer), "")
+	client, err := New(config)
+	if err != nil {
+		b.Fatalf("Err: %#v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			b.Fatalf("err: %v", err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func BenchmarkClientDo(b *testing.B) {
+	benchmarkClientDo(b, &Config{Metrics: true})
+}
+
+func BenchmarkClientDo_lean(b *testing.B) {
+	benchmarkClientDo(b, &Config{Metrics: true, Lean: true})
+}
@@ -0,0 +1,49 @@
+package retryablehttp
+
+import (
+	"context"
+
+	"github.com/lalamove/nui/nlogger"
+)
+
+// WithLogger returns a copy of ctx carrying logger, which Do uses instead
+// of Config.Logger for requests made with ctx. This lets client logs carry
+// request-scoped fields such as trace IDs. It stores the logger the same
+// way as nlogger.SetInContext, so loggers set through nlogger are picked
+// up as well.
+func WithLogger(ctx context.Context, logger Logger) context.Context {
+	return nlogger.SetInContext(ctx, logger)
+}
+
+// LoggerFromContext returns the logger carried by ctx, or nil if it has
+// none.
+func LoggerFromContext(ctx context.Context) Logger {
+	return nlogger.FromContext(ctx)
+}
+
+// logger returns the logger for requests made with ctx. It never returns
+// nil, even if Config.Logger was cleared after New.
+func (c *Client) logger(ctx context.Context) Logger {
+	if logger := LoggerFromContext(ctx); logger != nil {
+		return logger
+	}
+	if c.Logger == nil {
+		return NopLogger{}
+	}
+	return c.Logger
+}
+
+// NopLogger is a Logger which discards everything. Set it as Config.Logger
+// to silence the client, which otherwise logs to stderr.
+type NopLogger struct{}
+
+func (NopLogger) Debug(string)                              {}
+func (NopLogger) DebugWithFields(string, nlogger.EntryFunc) {}
+func (NopLogger) Info(string)                               {}
+func (NopLogger) InfoWithFields(string, nlogger.EntryFunc)  {}
+func (NopLogger) Warn(string)                               {}
+func (NopLogger) WarnWithFields(string, nlogger.EntryFunc)  {}
+func (NopLogger) Error(string)                              {}
+func (NopLogger) ErrorWithFields(string, nlogger.EntryFunc) {}
+func (NopLogger) Fatal(string)                              {}
+func (NopLogger) FatalWithFields(string, nlogger.EntryFunc) {}
@@ -0,0 +1,122 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lalamove/nui/nlogger"
+)
+
+// fieldLogger adds a fixed field to every entry, like a request-scoped
+// logger would.
+type fieldLogger struct {
+	nlogger.Structured
+	key, value string
+}
+
+func (l fieldLogger) DebugWithFields(msg string, ef nlogger.EntryFunc) {
+	l.Structured.DebugWithFields(msg, func(entry nlogger.Entry) {
+		entry.String(l.key, l.value)
+		ef(entry)
+	})
+}
+
+func TestClient_ContextLogger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	clientBuf := new(bytes.Buffer)
+	client, err := New(&Config{Logger: nlogger.New(clientBuf, "[HTTP]")})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	ctx := WithLogger(context.Background(), fieldLogger{nlogger.New(buf, "[HTTP]"), "trace_id", "abc123"})
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if out := buf.String(); !strings.Contains(out, "Sending request for method") || !strings.Contains(out, "trace_id=abc123") {
+		t.Fatalf("expected request-scoped fields in the context logger, got %q", out)
+	}
+	if out := clientBuf.String(); out != "" {
+		t.Fatalf("expected nothing logged to the client logger, got %q", out)
+	}
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	if logger := LoggerFromContext(context.Background()); logger != nil {
+		t.Fatalf("expected no logger, got %v", logger)
+	}
+	logger := nlogger.New(new(bytes.Buffer), "")
+	if got := LoggerFromContext(WithLogger(context.Background(), logger)); got != logger {
+		t.Fatalf("expected the context logger, got %v", got)
+	}
+}
+
+func TestNopLogger(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer ts.Close()
+
+	// Catch anything written to stderr, where the default logger goes.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	client, err := New(&Config{
+		Logger:       NopLogger{},
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp.Body.Close()
+	_, err = client.Get("ftp://example.com")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+
+	os.Stderr = stderr
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("expected no output, got %q", out)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
@@ -0,0 +1,79 @@
+package retryablehttp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// LongPoll repeatedly GETs url, delivering every 2xx response on the
+// response channel until ctx is cancelled, at which point both channels are
+// closed. The next poll is only sent once the previous response has been
+// received, so a slow consumer slows polling down rather than piling up
+// requests. Reading and closing each response body is up to the caller.
+//
+// When Do fails, or returns a non-2xx response, the error, a *StatusError in
+// the latter case, is sent on the error channel and polling resumes after a
+// backoff which grows with consecutive failures. Errors are reported on a
+// best-effort basis: if the previous error has not been received yet, the
+// new one is dropped.
+func (c *Client) LongPoll(ctx context.Context, url string) (<-chan *http.Response, <-chan error) {
+	responses := make(chan *http.Response)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(responses)
+		defer close(errs)
+
+		failures := 0
+		for ctx.Err() == nil {
+			req, err := NewRequest("GET", url, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			resp, err := c.Do(req.WithContext(ctx))
+			if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+				statusErr := newStatusError(req, resp)
+				statusErr.Body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, statusErrorBodyLimit))
+				err = statusErr
+			}
+			if err != nil {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case errs <- err:
+				default:
+				}
+
+				wait := c.Backoff(c.RetryWaitMin, c.RetryWaitMax, failures, nil)
+				failures++
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				continue
+			}
+			failures = 0
+
+			select {
+			case responses <- resp:
+			case <-ctx.Done():
+				resp.Body.Close()
+				return
+			}
+		}
+	}()
+
+	return responses, errs
+}
@@ -0,0 +1,130 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_LongPoll(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		// Fail once in the middle to exercise reconnecting.
+		if n == 2 {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{RetryMax: 1})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	responses, errs := client.LongPoll(ctx, ts.URL)
+
+	var bodies []string
+	for len(bodies) < 3 {
+		select {
+		case resp := <-responses:
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			bodies = append(bodies, string(body))
+		case err := <-errs:
+			t.Fatalf("unexpected error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for poll results")
+		}
+	}
+
+	// The 500 was retried by Do rather than surfaced.
+	expect := []string{"1", "3", "4"}
+	for i := range expect {
+		if bodies[i] != expect[i] {
+			t.Fatalf("expected %v, got %v", expect, bodies)
+		}
+	}
+
+	cancel()
+	for resp := range responses {
+		resp.Body.Close()
+	}
+	for range errs {
+	}
+
+	// Polling stops once the consumer stops receiving: at most one request
+	// beyond the delivered ones is in flight.
+	if n := atomic.LoadInt32(&hits); n > 5 {
+		t.Fatalf("expected polling to be bounded by the consumer, got %d requests", n)
+	}
+}
+
+func TestClient_LongPoll_errorStatus(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(401)
+		w.Write([]byte("who are you"))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = 50 * time.Millisecond
+	client.RetryWaitMax = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	responses, errs := client.LongPoll(ctx, ts.URL)
+
+	var errCount int
+	for responses != nil || errs != nil {
+		select {
+		case resp, ok := <-responses:
+			if !ok {
+				responses = nil
+				continue
+			}
+			resp.Body.Close()
+			t.Fatalf("expected no response to be delivered, got %d", resp.StatusCode)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			errCount++
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != 401 || string(statusErr.Body) != "who are you" {
+				t.Fatalf("expected a 401 StatusError, got: %v", err)
+			}
+		}
+	}
+
+	if errCount == 0 {
+		t.Fatalf("expected the 401 to be reported")
+	}
+	// Polls are spaced out by the backoff instead of looping.
+	if n := atomic.LoadInt32(&hits); n > 6 {
+		t.Fatalf("expected polling to back off, got %d requests", n)
+	}
+}
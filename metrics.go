@@ -1,6 +1,10 @@
 package retryablehttp
 
 import (
+	"reflect"
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -12,67 +16,114 @@ const (
 	doRetryCallCount        = "http_client_retry_do_count"
 	doRetryCallFailureCount = "http_client_retry_do_failure_count"
 	doRetryCallSuccessCount = "http_client_retry_do_success_count"
+	doRecoveredCount        = "http_client_do_recovered_count"
+	doStatusCount           = "http_client_do_status_count"
 
-	doDuration    = "http_client_task_duration"
-	retryDuration = "http_client_retry_duration"
+	doDuration     = "http_client_task_duration"
+	retryDuration  = "http_client_retry_duration"
+	backoffSeconds = "http_client_retry_backoff_seconds"
 )
 
-func initMetrics() (*retryHttpMetrics, error) {
+// initMetrics builds the collectors of a Client configured by c and
+// registers them with c.MetricsRegisterer, or with the default Prometheus
+// registerer when it is nil.
+func initMetrics(c *Config) (*retryHttpMetrics, error) {
+	labels := prometheus.Labels(c.MetricsLabels)
 	var prometheusMetrics = map[string]prometheus.Collector{
 		doCallCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: doCallCount,
-				Help: "Number of http Client.Do calls",
+				Name:        doCallCount,
+				Help:        "Number of http Client.Do calls",
+				ConstLabels: labels,
 			},
 			[]string{"total"},
 		),
 		doCallFailureCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: doCallFailureCount,
-				Help: "Number of http Client.Do failed calls",
+				Name:        doCallFailureCount,
+				Help:        "Number of http Client.Do failed calls",
+				ConstLabels: labels,
 			},
-			[]string{""},
+			[]string{"total"},
 		),
 		doCallSuccessCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: doCallSuccessCount,
-				Help: "Number of http Client.Do calls that succeeded",
+				Name:        doCallSuccessCount,
+				Help:        "Number of http Client.Do calls that succeeded",
+				ConstLabels: labels,
 			},
 			[]string{"total"},
 		),
 		doRetryCallCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: doRetryCallCount,
-				Help: "Number of http Client.Do retry calls",
+				Name:        doRetryCallCount,
+				Help:        "Number of http Client.Do retry calls",
+				ConstLabels: labels,
 			},
 			[]string{"total"},
 		),
 		doRetryCallFailureCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: doRetryCallFailureCount,
-				Help: "Number of http Client.Do failed  retry calls",
+				Name:        doRetryCallFailureCount,
+				Help:        "Number of http Client.Do failed  retry calls",
+				ConstLabels: labels,
+			},
+			[]string{"total"},
+		),
+		doRecoveredCount: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        doRecoveredCount,
+				Help:        "Number of http Client.Do calls that succeeded after a retry",
+				ConstLabels: labels,
 			},
 			[]string{"total"},
 		),
 		doDuration: prometheus.NewSummaryVec(
 			prometheus.SummaryOpts{
-				Name:       doDuration,
-				Help:       "Durations per http request made in a summary vector",
-				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.95: 0.005, 0.99: 0.001},
+				Name:        doDuration,
+				Help:        "Durations per http request made in a summary vector",
+				ConstLabels: labels,
+				Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.95: 0.005, 0.99: 0.001},
 			},
 			[]string{"request_duration"},
 		),
 		retryDuration: prometheus.NewSummaryVec(
 			prometheus.SummaryOpts{
-				Name:       retryDuration,
-				Help:       "Durations per http request retry in a summary vector",
-				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.95: 0.005, 0.99: 0.001},
+				Name:        retryDuration,
+				Help:        "Durations per http request retry in a summary vector",
+				ConstLabels: labels,
+				Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.95: 0.005, 0.99: 0.001},
 			},
 			[]string{"request_duration"},
 		),
+		backoffSeconds: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:        backoffSeconds,
+				Help:        "Time waited between http request attempts in seconds",
+				ConstLabels: labels,
+				Buckets:     backoffBuckets(c.RetryWaitMax),
+			},
+		),
 	}
 
-	if err := registerMetrics(prometheusMetrics); err != nil {
+	// Status codes are bounded to 0-599 plus "invalid", so this stays
+	// below ~600 series.
+	if c.MetricsPerStatusCode {
+		prometheusMetrics[doStatusCount] = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        doStatusCount,
+				Help:        "Number of http responses received per status code",
+				ConstLabels: labels,
+			},
+			[]string{"code"},
+		)
+	}
+
+	reg := c.MetricsRegisterer
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if err := registerMetrics(reg, prometheusMetrics); err != nil {
 		return nil, err
 	}
 
@@ -82,6 +133,7 @@ func initMetrics() (*retryHttpMetrics, error) {
 
 	var doRetries = prometheusMetrics[doRetryCallCount].(*prometheus.CounterVec)
 	var doRetriesFailures = prometheusMetrics[doRetryCallFailureCount].(*prometheus.CounterVec)
+	var doRecovered = prometheusMetrics[doRecoveredCount].(*prometheus.CounterVec)
 
 	var doDurations = prometheusMetrics[doDuration].(*prometheus.SummaryVec)
 	var doRetryDurations = prometheusMetrics[retryDuration].(*prometheus.SummaryVec)
@@ -95,10 +147,15 @@ func initMetrics() (*retryHttpMetrics, error) {
 		// retry counters
 		doRetries:        doRetries.WithLabelValues("http.do.retires"),
 		doRetriesFailure: doRetriesFailures.WithLabelValues("http.do.retries.failed"),
+		doRecovered:      doRecovered.WithLabelValues("http.do.recovered"),
 
 		// durations
 		doDuration:      doDurations.WithLabelValues("http.do.duration"),
 		doRetryDuration: doRetryDurations.WithLabelValues("http.do.retry.duration"),
+		doBackoff:       prometheusMetrics[backoffSeconds].(prometheus.Histogram),
+	}
+	if c.MetricsPerStatusCode {
+		metrics.doStatus = prometheusMetrics[doStatusCount].(*prometheus.CounterVec)
 	}
 	return metrics, nil
 }
@@ -109,17 +166,51 @@ type retryHttpMetrics struct {
 	doFailure        prometheus.Counter
 	doRetries        prometheus.Counter
 	doRetriesFailure prometheus.Counter
+	doRecovered      prometheus.Counter
+	doStatus         *prometheus.CounterVec // nil unless counting per status code
 	doDuration       prometheus.Observer
 	doRetryDuration  prometheus.Observer
+	doBackoff        prometheus.Histogram
+}
+
+// backoffBuckets returns histogram buckets doubling from a millisecond up
+// to the first bound of at least max. Clients sharing a registerer share
+// the buckets of the first one registered.
+func backoffBuckets(max time.Duration) []float64 {
+	buckets := []float64{time.Millisecond.Seconds()}
+	for bound := time.Millisecond; bound < max; {
+		bound *= 2
+		buckets = append(buckets, bound.Seconds())
+	}
+	return buckets
+}
+
+// observeStatus counts a response with the given status code. Codes outside
+// of 0-599 are counted together to keep cardinality bounded.
+func (m *retryHttpMetrics) observeStatus(code int) {
+	if m.doStatus == nil {
+		return
+	}
+	label := "invalid"
+	if code >= 0 && code <= 599 {
+		label = strconv.Itoa(code)
+	}
+	m.doStatus.WithLabelValues(label).Inc()
 }
 
-func registerMetrics(m map[string]prometheus.Collector) error {
-	for _, metric := range m {
-		var err = prometheus.Register(metric)
+// registerMetrics registers the collectors in m with reg, replacing any
+// which were already registered, by another Client for instance, with the
+// registered one so that all clients report into the same series. A
+// registered collector of another kind is an error.
+func registerMetrics(reg prometheus.Registerer, m map[string]prometheus.Collector) error {
+	for name, metric := range m {
+		var err = reg.Register(metric)
 		if err != nil {
-			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			are, ok := err.(prometheus.AlreadyRegisteredError)
+			if !ok || reflect.TypeOf(are.ExistingCollector) != reflect.TypeOf(metric) {
 				return err
 			}
+			m[name] = are.ExistingCollector
 		}
 	}
 	return nil
@@ -0,0 +1,331 @@
+package retryablehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClient_MetricsPerStatusCode(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every other response is a 503, retried until a 200.
+		if atomic.AddInt32(&hits, 1)%2 == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{Metrics: true, MetricsPerStatusCode: true})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	count := func(code string) float64 {
+		return testutil.ToFloat64(client.metrics.doStatus.WithLabelValues(code))
+	}
+	before200, before503 := count("200"), count("503")
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if v := count("200") - before200; v != 3 {
+		t.Fatalf("expected 3 responses with 200, got %v", v)
+	}
+	if v := count("503") - before503; v != 3 {
+		t.Fatalf("expected 3 responses with 503, got %v", v)
+	}
+}
+
+func TestRetryHttpMetrics_observeStatus(t *testing.T) {
+	metrics, err := initMetrics(&Config{MetricsPerStatusCode: true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	before := testutil.ToFloat64(metrics.doStatus.WithLabelValues("invalid"))
+	metrics.observeStatus(999)
+	if v := testutil.ToFloat64(metrics.doStatus.WithLabelValues("invalid")) - before; v != 1 {
+		t.Fatalf("expected out of range code to be counted as invalid, got %v", v)
+	}
+}
+
+func TestClient_MetricsRegisterer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var clients []*Client
+	var registries []*prometheus.Registry
+	for i := 0; i < 2; i++ {
+		reg := prometheus.NewRegistry()
+		client, err := New(&Config{Metrics: true, MetricsRegisterer: reg})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		clients = append(clients, client)
+		registries = append(registries, reg)
+	}
+
+	// Each client reports into its own registry.
+	for i := 0; i < 2; i++ {
+		resp, err := clients[0].Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp.Body.Close()
+	}
+	if v := testutil.ToFloat64(clients[0].metrics.doTotal); v != 2 {
+		t.Fatalf("expected 2 calls on the first client, got %v", v)
+	}
+	if v := testutil.ToFloat64(clients[1].metrics.doTotal); v != 0 {
+		t.Fatalf("expected no calls on the second client, got %v", v)
+	}
+
+	for i, reg := range registries {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		var found bool
+		for _, f := range families {
+			if f.GetName() == doCallCount {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("registry %d: %s not registered", i, doCallCount)
+		}
+	}
+}
+
+func TestClient_MetricsRegisterer_shared(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	reg := prometheus.NewRegistry()
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		client, err := New(&Config{Metrics: true, MetricsRegisterer: reg})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		clients = append(clients, client)
+	}
+
+	for _, client := range []*Client{clients[0], clients[1], clients[1]} {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// Both clients report into the registered series.
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != doCallCount {
+			continue
+		}
+		if v := f.GetMetric()[0].GetCounter().GetValue(); v != 3 {
+			t.Fatalf("expected 3 calls, got %v", v)
+		}
+		return
+	}
+	t.Fatalf("%s not registered", doCallCount)
+}
+
+// otherCounterVec is a collector of another type with the same
+// descriptors as a CounterVec.
+type otherCounterVec struct {
+	*prometheus.CounterVec
+}
+
+func TestClient_MetricsRegisterer_mismatch(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(otherCounterVec{prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: doCallCount, Help: "Number of http Client.Do calls"},
+		[]string{"total"},
+	)})
+
+	if _, err := New(&Config{Metrics: true, MetricsRegisterer: reg}); err == nil {
+		t.Fatalf("expected an error for a collector of another type")
+	}
+}
+
+func TestClient_MetricsLabels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	reg := prometheus.NewRegistry()
+	clients := make(map[string]*Client)
+	for _, service := range []string{"payments", "orders"} {
+		client, err := New(&Config{
+			Metrics:           true,
+			MetricsRegisterer: reg,
+			MetricsLabels:     map[string]string{"service": service},
+		})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		clients[service] = client
+	}
+
+	resp, err := clients["payments"].Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp.Body.Close()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	calls := make(map[string]float64)
+	for _, f := range families {
+		if f.GetName() != doCallCount {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "service" {
+					calls[l.GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	if calls["payments"] != 1 || calls["orders"] != 0 {
+		t.Fatalf("expected calls to be counted per service, got %v", calls)
+	}
+
+	// Differing label names can't share a registry.
+	_, err = New(&Config{
+		Metrics:           true,
+		MetricsRegisterer: reg,
+		MetricsLabels:     map[string]string{"upstream": "users"},
+	})
+	if err == nil {
+		t.Fatalf("expected error registering different label names")
+	}
+}
+
+func TestBackoffBuckets(t *testing.T) {
+	buckets := backoffBuckets(30 * time.Second)
+	if buckets[0] != 0.001 {
+		t.Fatalf("expected buckets to start at a millisecond, got %v", buckets[0])
+	}
+	if last := buckets[len(buckets)-1]; last < 30 || last > 60 {
+		t.Fatalf("expected buckets to end right above RetryWaitMax, got %v", last)
+	}
+	if buckets := backoffBuckets(0); len(buckets) != 1 {
+		t.Fatalf("expected a single bucket without RetryWaitMax, got %v", buckets)
+	}
+}
+
+func TestClient_MetricsBackoff(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer ts.Close()
+
+	reg := prometheus.NewRegistry()
+	client, err := New(&Config{
+		Metrics:           true,
+		MetricsRegisterer: reg,
+		RetryWaitMin:      10 * time.Millisecond,
+		RetryWaitMax:      10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp.Body.Close()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != backoffSeconds {
+			continue
+		}
+		h := f.GetMetric()[0].GetHistogram()
+		if h.GetSampleCount() != 2 {
+			t.Fatalf("expected 2 waits, got %d", h.GetSampleCount())
+		}
+		// The time actually slept, at least the 20ms planned.
+		if sum := h.GetSampleSum(); sum < 0.02 || sum > 0.5 {
+			t.Fatalf("expected about 20ms of waits, got %vs", sum)
+		}
+		return
+	}
+	t.Fatalf("%s not registered", backoffSeconds)
+}
+
+func TestClient_MetricsBackoff_canceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	reg := prometheus.NewRegistry()
+	client, err := New(&Config{
+		Metrics:           true,
+		MetricsRegisterer: reg,
+		RetryWaitMin:      time.Hour,
+		RetryWaitMax:      time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.Do(req.WithContext(ctx)); err != context.Canceled {
+		t.Fatalf("expected the context error, got: %v", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != backoffSeconds {
+			continue
+		}
+		// The wait cut short is recorded as slept, not as planned.
+		h := f.GetMetric()[0].GetHistogram()
+		if sum := h.GetSampleSum(); h.GetSampleCount() != 1 || sum <= 0 || sum > 1 {
+			t.Fatalf("expected one wait cut short within 50ms, got %d totalling %vs", h.GetSampleCount(), sum)
+		}
+		return
+	}
+	t.Fatalf("%s not registered", backoffSeconds)
+}
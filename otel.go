@@ -0,0 +1,80 @@
+package retryablehttp
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// otelStatsHook records request statistics with OpenTelemetry instruments
+// equivalent to the Prometheus metrics.
+type otelStatsHook struct {
+	doTotal          metric.Int64Counter
+	doSuccess        metric.Int64Counter
+	doFailure        metric.Int64Counter
+	doRetries        metric.Int64Counter
+	doRetriesFailure metric.Int64Counter
+	doDuration       metric.Float64Histogram
+	doRetryDuration  metric.Float64Histogram
+}
+
+// NewOTelStatsHook returns a StatsHook which records request counters and
+// duration histograms with instruments created from meter. It returns an
+// error if an instrument cannot be created.
+func NewOTelStatsHook(meter metric.Meter) (StatsHook, error) {
+	o := &otelStatsHook{}
+	var err error
+	if o.doTotal, err = meter.Int64Counter(doCallCount,
+		metric.WithDescription("Number of http Client.Do calls")); err != nil {
+		return nil, err
+	}
+	if o.doSuccess, err = meter.Int64Counter(doCallSuccessCount,
+		metric.WithDescription("Number of http Client.Do calls that succeeded")); err != nil {
+		return nil, err
+	}
+	if o.doFailure, err = meter.Int64Counter(doCallFailureCount,
+		metric.WithDescription("Number of http Client.Do failed calls")); err != nil {
+		return nil, err
+	}
+	if o.doRetries, err = meter.Int64Counter(doRetryCallCount,
+		metric.WithDescription("Number of http Client.Do retry calls")); err != nil {
+		return nil, err
+	}
+	if o.doRetriesFailure, err = meter.Int64Counter(doRetryCallFailureCount,
+		metric.WithDescription("Number of http Client.Do failed retry calls")); err != nil {
+		return nil, err
+	}
+	if o.doDuration, err = meter.Float64Histogram(doDuration,
+		metric.WithDescription("Durations per http request"), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if o.doRetryDuration, err = meter.Float64Histogram(retryDuration,
+		metric.WithDescription("Durations per http request retry"), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *otelStatsHook) Attempt(attempt int, d time.Duration, err error) {
+	if attempt == 0 {
+		return
+	}
+	ctx := context.Background()
+	o.doRetries.Add(ctx, 1)
+	o.doRetryDuration.Record(ctx, d.Seconds())
+	if err != nil {
+		o.doRetriesFailure.Add(ctx, 1)
+	}
+}
+
+func (o *otelStatsHook) Done(d time.Duration, err error) {
+	ctx := context.Background()
+	o.doTotal.Add(ctx, 1)
+	o.doDuration.Record(ctx, d.Seconds())
+	if err != nil {
+		o.doFailure.Add(ctx, 1)
+	} else {
+		o.doSuccess.Add(ctx, 1)
+	}
+}
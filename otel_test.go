@@ -0,0 +1,88 @@
+package retryablehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTelStatsHook(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+
+	hook, err := NewOTelStatsHook(provider.Meter("retryablehttp"))
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client, err := New(&Config{StatsHook: hook})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sums := map[string]int64{}
+	histograms := map[string]uint64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					sums[m.Name] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					histograms[m.Name] += dp.Count
+				}
+			}
+		}
+	}
+
+	expectSums := map[string]int64{
+		doCallCount:        1,
+		doCallSuccessCount: 1,
+		doRetryCallCount:   2,
+	}
+	for name, expect := range expectSums {
+		if sums[name] != expect {
+			t.Fatalf("%s: expected %d, got %d", name, expect, sums[name])
+		}
+	}
+	if sums[doCallFailureCount] != 0 || sums[doRetryCallFailureCount] != 0 {
+		t.Fatalf("expected no failures, got: %v", sums)
+	}
+	if histograms[doDuration] != 1 {
+		t.Fatalf("expected 1 request duration, got %d", histograms[doDuration])
+	}
+	if histograms[retryDuration] != 2 {
+		t.Fatalf("expected 2 retry durations, got %d", histograms[retryDuration])
+	}
+}
@@ -0,0 +1,60 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// peekedBody is a response body whose start was read ahead of the caller.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// readBodyStart reads up to limit bytes of the body of resp and puts them
+// back ahead of the rest of the body. It returns the bytes read, and whether
+// they are the whole body, in which case the original body is closed. On a
+// read error the body is closed and the error returned.
+func readBodyStart(resp *http.Response, limit int64) ([]byte, bool, error) {
+	body := resp.Body
+	buf, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		body.Close()
+		return nil, false, err
+	}
+	if int64(len(buf)) > limit {
+		resp.Body = &peekedBody{
+			Reader: io.MultiReader(bytes.NewReader(buf), body),
+			Closer: body,
+		}
+		return buf[:limit], false, nil
+	}
+	body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	return buf, true, nil
+}
+
+// peekBody reads up to PeekResponseBodyLimit bytes of the body of resp into
+// memory and swaps them in as the body, so that CheckRetry can read them
+// freely. The returned func puts the full body back, peeked bytes first,
+// and must be called once CheckRetry is done.
+func (c *Client) peekBody(resp *http.Response) func() {
+	if resp == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return func() {}
+	}
+	body := resp.Body
+	peeked, err := ioutil.ReadAll(io.LimitReader(body, c.PeekResponseBodyLimit))
+	if err != nil {
+		c.logger(context.Background()).Error(err.Error())
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(peeked))
+	return func() {
+		resp.Body = &peekedBody{
+			Reader: io.MultiReader(bytes.NewReader(peeked), body),
+			Closer: body,
+		}
+	}
+}
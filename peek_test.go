@@ -0,0 +1,70 @@
+package retryablehttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_PeekResponseBody(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.Write([]byte(`{"error":"try again"}`))
+			return
+		}
+		w.Write([]byte(`{"result":"` + strings.Repeat("x", 100) + `"}`))
+	}))
+	defer ts.Close()
+
+	var peeked []string
+	client, err := New(&Config{
+		RetryWaitMin:          time.Millisecond,
+		RetryWaitMax:          time.Millisecond,
+		PeekResponseBody:      true,
+		PeekResponseBodyLimit: 32,
+		CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+			if err != nil {
+				return false, err
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return false, err
+			}
+			peeked = append(peeked, string(body))
+			return strings.Contains(string(body), "try again"), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+	// The final response is whole, although CheckRetry read its start.
+	if want := `{"result":"` + strings.Repeat("x", 100) + `"}`; string(body) != want {
+		t.Fatalf("expected the full body, got %q", body)
+	}
+	if len(peeked) != 3 || peeked[0] != `{"error":"try again"}` {
+		t.Fatalf("unexpected peeked bodies: %q", peeked)
+	}
+	if len(peeked[2]) != 32 {
+		t.Fatalf("expected the peek to be cut at 32 bytes, got %d", len(peeked[2]))
+	}
+}
@@ -0,0 +1,52 @@
+package retryablehttp
+
+import (
+	"context"
+	"net/http"
+)
+
+// AnyRetry returns a CheckRetry which retries when any of policies does,
+// such as DefaultRetryPolicy and a policy looking for a JSON error body.
+// Policies are called in order until one asks for a retry.
+//
+// The error returned is the first non-nil error returned by the policies
+// called. The context is checked first, and a policy failing with the
+// context's error ends the evaluation without a retry.
+func AnyRetry(policies ...CheckRetry) CheckRetry {
+	return combineRetry(true, policies)
+}
+
+// AllRetry returns a CheckRetry which retries only when all of policies
+// do, and never without policies. Policies are called in order until one
+// refuses to retry. Errors and the context are handled like with AnyRetry.
+func AllRetry(policies ...CheckRetry) CheckRetry {
+	return combineRetry(false, policies)
+}
+
+// combineRetry returns a CheckRetry which calls policies until one returns
+// stopOn.
+func combineRetry(stopOn bool, policies []CheckRetry) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		// do not retry on context.Canceled or context.DeadlineExceeded
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		var firstErr error
+		retry := !stopOn && len(policies) > 0
+		for _, policy := range policies {
+			ok, checkErr := policy(ctx, resp, err)
+			if firstErr == nil {
+				firstErr = checkErr
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil && checkErr == ctxErr {
+				return false, firstErr
+			}
+			if ok == stopOn {
+				retry = stopOn
+				break
+			}
+		}
+		return retry, firstErr
+	}
+}
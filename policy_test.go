@@ -0,0 +1,104 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAnyRetry_AllRetry(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	var calls []string
+	policy := func(name string, retry bool, err error) CheckRetry {
+		return func(context.Context, *http.Response, error) (bool, error) {
+			calls = append(calls, name)
+			return retry, err
+		}
+	}
+
+	cases := []struct {
+		name        string
+		check       CheckRetry
+		expectRetry bool
+		expectErr   error
+		expectCalls int
+	}{
+		{"any, none retry", AnyRetry(policy("1", false, nil), policy("2", false, errB)), false, errB, 2},
+		{"any, stops at first retry", AnyRetry(policy("1", false, errA), policy("2", true, errB), policy("3", true, nil)), true, errA, 2},
+		{"any, empty", AnyRetry(), false, nil, 0},
+		{"all, all retry", AllRetry(policy("1", true, nil), policy("2", true, errB)), true, errB, 2},
+		{"all, stops at first refusal", AllRetry(policy("1", true, errA), policy("2", false, errB), policy("3", true, nil)), false, errA, 2},
+		{"all, empty", AllRetry(), false, nil, 0},
+	}
+	for _, tc := range cases {
+		calls = nil
+		retry, err := tc.check(context.Background(), &http.Response{StatusCode: 200}, nil)
+		if retry != tc.expectRetry {
+			t.Fatalf("%s: expected retry %v, got %v", tc.name, tc.expectRetry, retry)
+		}
+		if err != tc.expectErr {
+			t.Fatalf("%s: expected error %v, got %v", tc.name, tc.expectErr, err)
+		}
+		if len(calls) != tc.expectCalls {
+			t.Fatalf("%s: expected %d policies called, got %v", tc.name, tc.expectCalls, calls)
+		}
+	}
+}
+
+func TestAnyRetry_context(t *testing.T) {
+	var calls int
+	counting := func(context.Context, *http.Response, error) (bool, error) {
+		calls++
+		return true, nil
+	}
+
+	// A done context stops before any policy is called.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	retry, err := AnyRetry(counting)(ctx, nil, nil)
+	if retry || err != context.Canceled {
+		t.Fatalf("expected no retry on canceled context, got %v, %v", retry, err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no policy to be called, got %d", calls)
+	}
+
+	// A policy failing with the context's error ends the evaluation.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancelling := func(ctx context.Context, _ *http.Response, _ error) (bool, error) {
+		cancel()
+		return false, ctx.Err()
+	}
+	retry, err = AnyRetry(cancelling, counting)(ctx, nil, nil)
+	if retry || err != context.Canceled {
+		t.Fatalf("expected no retry once canceled, got %v, %v", retry, err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected the evaluation to stop, got %d more calls", calls)
+	}
+}
+
+func TestAnyRetry_wrapsDefault(t *testing.T) {
+	onTeapot := func(_ context.Context, resp *http.Response, err error) (bool, error) {
+		return SafeStatusCode(resp) == http.StatusTeapot, err
+	}
+	check := AnyRetry(DefaultRetryPolicy, onTeapot)
+	for _, tc := range []struct {
+		code  int
+		retry bool
+	}{
+		{200, false},
+		{418, true},
+		{503, true},
+		{501, false},
+	} {
+		retry, err := check(context.Background(), &http.Response{StatusCode: tc.code}, nil)
+		if err != nil {
+			t.Fatalf("%d: Err: %#v", tc.code, err)
+		}
+		if retry != tc.retry {
+			t.Fatalf("%d: expected retry %v, got %v", tc.code, tc.retry, retry)
+		}
+	}
+}
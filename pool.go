@@ -0,0 +1,31 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"sync"
+)
+
+// bufferPool holds byte buffers shared by body draining and small body reads
+// so that they don't allocate on every call.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, respReadLimit))
+	},
+}
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer resets buf and returns it to the pool. Buffers which have grown
+// well past respReadLimit are dropped rather than pinned in the pool.
+func putBuffer(buf *bytes.Buffer) {
+	if int64(buf.Cap()) > 4*respReadLimit {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
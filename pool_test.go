@@ -0,0 +1,118 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/lalamove/nui/nlogger"
+)
+
+type trackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestClient_drainBody(t *testing.T) {
+	client, err := New(&Config{Logger: nlogger.New(ioutil.Discard, "")})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var r io.Reader = bytes.NewReader(bytes.Repeat([]byte("x"), i*200))
+			if i%5 == 0 {
+				r = errReader{}
+			}
+			body := &trackingBody{Reader: r}
+			client.drainBody(body)
+			if !body.closed {
+				t.Errorf("body %d was not closed", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Buffers handed back to the pool must come out empty.
+	for i := 0; i < 10; i++ {
+		buf := getBuffer()
+		if buf.Len() != 0 {
+			t.Fatalf("expected empty buffer, got %d bytes", buf.Len())
+		}
+		buf.WriteString("dirty")
+		putBuffer(buf)
+	}
+}
+
+func TestClient_drainBody_limit(t *testing.T) {
+	cases := []struct {
+		limit int64
+		size  int
+		full  bool
+	}{
+		{0, int(respReadLimit), true},
+		{0, 10 << 10, false},
+		{-1, 2 << 20, true},
+		{100, 100, true},
+		{100, 101, false},
+		{100, 10 << 10, false},
+	}
+	for _, tc := range cases {
+		client, err := New(&Config{ResponseDrainLimit: tc.limit})
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		body := ioutil.NopCloser(bytes.NewReader(make([]byte, tc.size)))
+		if full := client.drainBody(body); full != tc.full {
+			t.Fatalf("limit %d, size %d: expected %v, got %v", tc.limit, tc.size, tc.full, full)
+		}
+	}
+}
+
+func BenchmarkDrainBody(b *testing.B) {
+	client, err := New(&Config{})
+	if err != nil {
+		b.Fatalf("Err: %#v", err)
+	}
+	payload := bytes.Repeat([]byte("x"), int(respReadLimit))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.drainBody(ioutil.NopCloser(bytes.NewReader(payload)))
+	}
+}
+
+// BenchmarkDrainBody_unpooled reads into a fresh buffer on every call, as a
+// baseline for BenchmarkDrainBody.
+func BenchmarkDrainBody_unpooled(b *testing.B) {
+	payload := bytes.Repeat([]byte("x"), int(respReadLimit))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body := ioutil.NopCloser(bytes.NewReader(payload))
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(io.LimitReader(body, respReadLimit))
+		body.Close()
+	}
+}
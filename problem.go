@@ -0,0 +1,97 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// ErrNotProblem is returned by ParseProblem for responses which are not
+// application/problem+json.
+var ErrNotProblem = errors.New("response is not application/problem+json")
+
+// ProblemDetails is an RFC 7807 problem details object, as returned in
+// application/problem+json error responses by many APIs.
+type ProblemDetails struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ParseProblem decodes the application/problem+json body of resp. The body
+// is drained and closed in any case. It returns ErrNotProblem for other
+// content types.
+func ParseProblem(resp *http.Response) (*ProblemDetails, error) {
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, respReadLimit))
+		resp.Body.Close()
+	}()
+
+	if !isProblemContentType(resp.Header.Get("Content-Type")) {
+		return nil, ErrNotProblem
+	}
+	return decodeProblem(resp.Body)
+}
+
+func decodeProblem(r io.Reader) (*ProblemDetails, error) {
+	var problem ProblemDetails
+	if err := json.NewDecoder(r).Decode(&problem); err != nil {
+		return nil, err
+	}
+	return &problem, nil
+}
+
+func isProblemContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/problem+json"
+}
+
+// StatusError is returned by DoDecode and DoJSON for non-2xx responses.
+// Problem holds the problem details of application/problem+json responses,
+// and is nil otherwise.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Problem    *ProblemDetails
+
+	// Body is the start of the response body, set by DoJSON and LongPoll
+	// which close the response.
+	Body []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status code %d", e.Method, e.URL, e.StatusCode)
+}
+
+// newStatusError returns the StatusError for resp, the response to req.
+// Problem details are parsed from a copy of the body, so the caller can
+// still read it.
+func newStatusError(req *Request, resp *http.Response) *StatusError {
+	statusErr := &StatusError{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+	}
+	if resp.Body == nil || !isProblemContentType(resp.Header.Get("Content-Type")) {
+		return statusErr
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return statusErr
+	}
+	if problem, err := decodeProblem(bytes.NewReader(body)); err == nil {
+		statusErr.Problem = problem
+	}
+	return statusErr
+}
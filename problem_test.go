@@ -0,0 +1,85 @@
+package retryablehttp
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const sampleProblem = `{
+	"type": "https://example.com/probs/out-of-credit",
+	"title": "You do not have enough credit.",
+	"status": 403,
+	"detail": "Your current balance is 30, but that costs 50.",
+	"instance": "/account/12345/msgs/abc"
+}`
+
+func TestParseProblem(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 403,
+		Header:     http.Header{"Content-Type": []string{"application/problem+json; charset=utf-8"}},
+		Body:       ioutil.NopCloser(strings.NewReader(sampleProblem)),
+	}
+	problem, err := ParseProblem(resp)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := ProblemDetails{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   403,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+	}
+	if *problem != expected {
+		t.Fatalf("bad problem: %#v", problem)
+	}
+
+	resp = &http.Response{
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(strings.NewReader(`{}`)),
+	}
+	if _, err := ParseProblem(resp); err != ErrNotProblem {
+		t.Fatalf("expected ErrNotProblem, got %v", err)
+	}
+}
+
+func TestDoDecode_problem(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(403)
+		w.Write([]byte(sampleProblem))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	_, resp, err := DoDecode[map[string]string](client, req)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+	if statusErr.StatusCode != 403 || statusErr.Problem == nil || statusErr.Problem.Title != "You do not have enough credit." {
+		t.Fatalf("bad status error: %#v", statusErr)
+	}
+
+	// The body is still there for the caller.
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != sampleProblem {
+		t.Fatalf("bad body: %q", body)
+	}
+}
@@ -0,0 +1,26 @@
+package retryablehttp
+
+import "io"
+
+// ProgressHook is called as the body of a request is sent, with the number
+// of bytes written so far in the current attempt and the total to write,
+// the request's ContentLength, which is 0 or -1 when unknown. written goes
+// back to 0 when the body is rewound for a retry.
+type ProgressHook func(written, total int64)
+
+// progressBody reports reads of a request body to a ProgressHook.
+type progressBody struct {
+	io.ReadCloser
+	hook    ProgressHook
+	written int64
+	total   int64
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.written += int64(n)
+		b.hook(b.written, b.total)
+	}
+	return n, err
+}
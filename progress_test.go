@@ -0,0 +1,59 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_ProgressHook(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var totals []int64 // bytes written by the end of each attempt
+	client, err := New(&Config{
+		ProgressHook: func(written, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			if total != 100<<10 {
+				t.Errorf("expected a total of %d, got %d", 100<<10, total)
+			}
+			if n := len(totals); n == 0 || written < totals[n-1] {
+				totals = append(totals, written)
+			} else {
+				totals[n-1] = written
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Post(ts.URL, "application/octet-stream", bytes.Repeat([]byte("x"), 100<<10))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(totals) != 2 || totals[0] != 100<<10 || totals[1] != 100<<10 {
+		t.Fatalf("expected the full body to be reported for each of 2 attempts, got %v", totals)
+	}
+}
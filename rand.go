@@ -0,0 +1,30 @@
+package retryablehttp
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// rnd is the source of randomness for all jitter in the package. A
+// *rand.Rand is not safe for concurrent use, so it is guarded by rndMu.
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// SetRand replaces the source of randomness used for jitter, such as in
+// LinearJitterBackoff and FullJitterBackoff. It is meant for tests which
+// need reproducible backoffs.
+func SetRand(r *rand.Rand) {
+	rndMu.Lock()
+	rnd = r
+	rndMu.Unlock()
+}
+
+// randFloat64 returns a random number in [0.0, 1.0) from the package source.
+func randFloat64() float64 {
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	return rnd.Float64()
+}
@@ -0,0 +1,59 @@
+package retryablehttp
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetRand(t *testing.T) {
+	defer SetRand(rand.New(rand.NewSource(time.Now().UnixNano())))
+
+	run := func() []time.Duration {
+		SetRand(rand.New(rand.NewSource(42)))
+		var waits []time.Duration
+		for i := 0; i < 5; i++ {
+			waits = append(waits,
+				LinearJitterBackoff(time.Millisecond, time.Second, i, nil),
+				FullJitterBackoff(time.Millisecond, time.Second, i, nil))
+		}
+		return waits
+	}
+
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("%d: expected reproducible wait %s, got %s", i, first[i], second[i])
+		}
+	}
+}
+
+func TestLinearJitterBackoff_concurrent(t *testing.T) {
+	const n = 200
+	waits := make([]time.Duration, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			waits[i] = LinearJitterBackoff(time.Millisecond, time.Second, 0, nil)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	// Goroutines entering together must still get spread out waits.
+	distinct := make(map[time.Duration]bool)
+	for _, w := range waits {
+		if w < time.Millisecond || w > time.Second {
+			t.Fatalf("wait %s out of range", w)
+		}
+		distinct[w] = true
+	}
+	if len(distinct) < n*9/10 {
+		t.Fatalf("expected spread out waits, got %d distinct of %d", len(distinct), n)
+	}
+}
@@ -0,0 +1,36 @@
+package retryablehttp
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// hostLimiter returns the limiter PerHostRateLimit gives for host, asking
+// for it only once per host.
+func (c *Client) hostLimiter(host string) *rate.Limiter {
+	if l, ok := c.hostLimiters.Load(host); ok {
+		return l.(*rate.Limiter)
+	}
+	l, _ := c.hostLimiters.LoadOrStore(host, c.PerHostRateLimit(host))
+	return l.(*rate.Limiter)
+}
+
+// waitRateLimit blocks until the rate limit of host, if any, allows another
+// attempt. It fails with ErrRateLimited when the wait would outlast ctx.
+func (c *Client) waitRateLimit(ctx context.Context, host string) error {
+	if c.PerHostRateLimit == nil {
+		return nil
+	}
+	l := c.hostLimiter(host)
+	if l == nil {
+		return nil
+	}
+	if err := l.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrRateLimited
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestClient_PerHostRateLimit(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	slow := httptest.NewServer(handler)
+	defer slow.Close()
+	fast := httptest.NewServer(handler)
+	defer fast.Close()
+
+	slowURL, _ := url.Parse(slow.URL)
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	client, err := New(&Config{
+		PerHostRateLimit: func(host string) *rate.Limiter {
+			mu.Lock()
+			calls[host]++
+			mu.Unlock()
+			if host == slowURL.Host {
+				return rate.NewLimiter(rate.Every(50*time.Millisecond), 1)
+			}
+			return rate.NewLimiter(rate.Inf, 1)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	get := func(u string, n int) time.Duration {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			resp, err := client.Get(u)
+			if err != nil {
+				t.Fatalf("Err: %#v", err)
+			}
+			resp.Body.Close()
+		}
+		return time.Since(start)
+	}
+
+	var slowTook, fastTook time.Duration
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); slowTook = get(slow.URL, 4) }()
+	go func() { defer wg.Done(); fastTook = get(fast.URL, 4) }()
+	wg.Wait()
+
+	if slowTook < 150*time.Millisecond {
+		t.Fatalf("slow host not throttled: took %s", slowTook)
+	}
+	if fastTook > 100*time.Millisecond {
+		t.Fatalf("fast host throttled: took %s", fastTook)
+	}
+	for host, n := range calls {
+		if n != 1 {
+			t.Fatalf("PerHostRateLimit called %d times for %s", n, host)
+		}
+	}
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 hosts, got %v", calls)
+	}
+}
+
+func TestClient_PerHostRateLimit_Deadline(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer ts.Close()
+
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	client, err := New(&Config{
+		PerHostRateLimit: func(string) *rate.Limiter { return limiter },
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	start := time.Now()
+	_, err = client.Do(req.WithContext(ctx))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %#v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("waited for the limiter past the deadline")
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("expected 1 hit, got %d", n)
+	}
+}
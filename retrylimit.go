@@ -0,0 +1,18 @@
+package retryablehttp
+
+import "context"
+
+// acquireRetrySlot blocks until fewer than MaxConcurrentRetries retry
+// attempts are in progress, or ctx is done. First attempts, and clients
+// without a limit, never wait. The returned func releases the slot.
+func (c *Client) acquireRetrySlot(ctx context.Context, attempt int) (func(), error) {
+	if attempt == 0 || c.retrySlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.retrySlots <- struct{}{}:
+		return func() { <-c.retrySlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
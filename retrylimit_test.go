@@ -0,0 +1,60 @@
+package retryablehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_MaxConcurrentRetries(t *testing.T) {
+	var inFlight, maxInFlight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Attempt") != "0" {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{MaxConcurrentRetries: 2})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RequestLogHook = func(_ Logger, req *http.Request, attempt int) {
+		req.Header.Set("X-Attempt", strconv.Itoa(attempt))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Get(ts.URL); err == nil {
+				t.Errorf("expected error")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 2 {
+		t.Fatalf("expected at most 2 concurrent retries, got %d", maxInFlight)
+	}
+	if maxInFlight == 0 {
+		t.Fatalf("expected retries to reach the server")
+	}
+}
@@ -0,0 +1,21 @@
+package retryablehttp
+
+import "context"
+
+type retryMaxKey struct{}
+
+// WithRetryMax returns a copy of ctx overriding the client's RetryMax with n
+// for requests made with it, so that a single call site can retry more, or
+// less, than the client default.
+func WithRetryMax(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, retryMaxKey{}, n)
+}
+
+// retryMax returns the number of retries allowed for a request made with
+// ctx.
+func (c *Client) retryMax(ctx context.Context) int {
+	if n, ok := ctx.Value(retryMaxKey{}).(int); ok {
+		return n
+	}
+	return c.RetryMax
+}
@@ -0,0 +1,60 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_WithRetryMax(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		RetryMax:     4,
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	for _, tc := range []struct {
+		ctx        context.Context
+		expectHits int32
+	}{
+		{WithRetryMax(context.Background(), 1), 2},
+		{WithRetryMax(context.Background(), 6), 7},
+		{context.Background(), 5},
+	} {
+		atomic.StoreInt32(&hits, 0)
+		req, err := NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		_, err = client.Do(req.WithContext(tc.ctx))
+		var maxErr *MaxRetriesError
+		if !errors.As(err, &maxErr) {
+			t.Fatalf("expected a MaxRetriesError, got %#v", err)
+		}
+		if n := atomic.LoadInt32(&hits); n != tc.expectHits {
+			t.Fatalf("expected %d requests, got %d", tc.expectHits, n)
+		}
+		if maxErr.Attempts != int(tc.expectHits) {
+			t.Fatalf("expected %d attempts in the error, got %d", tc.expectHits, maxErr.Attempts)
+		}
+		if want := fmt.Sprintf("giving up after %d attempts", tc.expectHits); !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in %q", want, err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package retryablehttp
+
+import "net/http"
+
+// rewriteURL returns req with its URL replaced by URLRewriter for the given
+// attempt. req itself and its URL are left untouched.
+func (c *Client) rewriteURL(req *http.Request, attempt int) *http.Request {
+	if c.URLRewriter == nil {
+		return req
+	}
+	u := *req.URL
+	rewritten := c.URLRewriter(&u, attempt)
+	if rewritten == nil {
+		return req
+	}
+
+	out := req.WithContext(req.Context())
+	out.URL = rewritten
+	// Follow the rewritten host unless the Host header was set explicitly.
+	if req.Host == req.URL.Host {
+		out.Host = ""
+	}
+	return out
+}
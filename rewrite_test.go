@@ -0,0 +1,63 @@
+package retryablehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_URLRewriter(t *testing.T) {
+	var primaryHits, secondaryHits int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryHits, 1)
+		w.WriteHeader(503)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondaryHits, 1)
+		if r.URL.Query().Get("attempt") != "1" {
+			t.Errorf("bad query: %s", r.URL.RawQuery)
+		}
+		w.WriteHeader(200)
+	}))
+	defer secondary.Close()
+	secondaryURL, err := url.Parse(secondary.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client, err := New(&Config{
+		URLRewriter: func(u *url.URL, attempt int) *url.URL {
+			if attempt > 0 {
+				u.Host = secondaryURL.Host
+				u.RawQuery = "attempt=1"
+			}
+			return u
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	req, err := NewRequest("GET", primary.URL+"/foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if primaryHits != 1 || secondaryHits != 1 {
+		t.Fatalf("expected one request to each host, got %d and %d", primaryHits, secondaryHits)
+	}
+	if req.URL.String() != primary.URL+"/foo" {
+		t.Fatalf("expected the original URL to be untouched, got %s", req.URL)
+	}
+}
@@ -0,0 +1,62 @@
+package retryablehttp
+
+import "net/http"
+
+// RoundTripper is an http.RoundTripper sending requests through Client,
+// with retries. It lets retries be plugged into an *http.Client built
+// elsewhere, as transport middleware. Request bodies are read into memory
+// to be replayed.
+//
+// Each attempt is sent by Client.HttpClient, so the transport of that
+// client is the next round tripper of the chain. It must not retry itself,
+// or every retry of RoundTripper would be multiplied by its own, and must
+// never lead back to the RoundTripper.
+type RoundTripper struct {
+	Client *Client
+}
+
+// NewRoundTripper returns a RoundTripper with a Client configured by a copy
+// of c, whose attempts are sent by next in place of c.HttpClient. c itself
+// is left untouched. Redirects are left to the *http.Client using the
+// RoundTripper.
+func NewRoundTripper(next http.RoundTripper, c *Config) (*RoundTripper, error) {
+	cfg := *c
+	cfg.HttpClient = &http.Client{
+		Transport: next,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	client, err := New(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &RoundTripper{Client: client}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	req, err := FromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer req.Close()
+	resp, err := rt.Client.Do(req)
+	if err != nil {
+		// A RoundTripper returns a response or an error, not both.
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// StandardClient returns an *http.Client sending its requests through c,
+// with retries, for libraries which only accept an *http.Client. Request
+// bodies are read into memory to be replayed, and each request's context
+// is honored as with Do. Redirects are followed by c.HttpClient, so the
+// returned client only ever sees final responses.
+func (c *Client) StandardClient() *http.Client {
+	return &http.Client{Transport: &RoundTripper{Client: c}}
+}
@@ -0,0 +1,177 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_StandardClient(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Test") != "yes" {
+			t.Errorf("missing header")
+		}
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(500)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := http.NewRequest("POST", ts.URL, ioutil.NopCloser(strings.NewReader("hello")))
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	req.Header.Set("X-Test", "yes")
+	resp, err := client.StandardClient().Do(req)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if resp.StatusCode != 200 || string(body) != "hello" {
+		t.Fatalf("expected the replayed body, got %d %q", resp.StatusCode, body)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestClient_StandardClient_context(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		RetryWaitMin: time.Second,
+		RetryWaitMax: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	start := time.Now()
+	_, err = client.StandardClient().Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to stop retries, got %#v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("retries outlived the request context")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r, err := http.NewRequest("PUT", "http://example.com/foo", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	r.Header.Set("X-Test", "yes")
+
+	req, err := FromRequest(r)
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if req.Method != "PUT" || req.URL.String() != "http://example.com/foo" {
+		t.Fatalf("bad request: %s %s", req.Method, req.URL)
+	}
+	if req.Header.Get("X-Test") != "yes" {
+		t.Fatalf("headers not copied")
+	}
+	if req.ContentLength != 5 {
+		t.Fatalf("expected ContentLength 5, got %d", req.ContentLength)
+	}
+	for i := 0; i < 2; i++ {
+		body, err := req.body()
+		if err != nil {
+			t.Fatalf("Err: %#v", err)
+		}
+		data, _ := ioutil.ReadAll(body)
+		if string(data) != "hello" {
+			t.Fatalf("expected a replayable body, got %q", data)
+		}
+	}
+	req.Close()
+}
+
+func TestRoundTripper(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/redirect":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case atomic.AddInt32(&hits, 1) < 3:
+			w.WriteHeader(503)
+		}
+	}))
+	defer ts.Close()
+
+	// Count the attempts reaching the transport below the RoundTripper.
+	var attempts int32
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	rt, err := NewRoundTripper(next, &Config{
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Get(ts.URL + "/redirect")
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+	// One attempt for the redirect, followed by the outer client, and
+	// three for the failing endpoint.
+	if n := atomic.LoadInt32(&attempts); n != 4 {
+		t.Fatalf("expected 4 attempts through the transport, got %d", n)
+	}
+}
+
+func TestNewRoundTripper_configUntouched(t *testing.T) {
+	hc := &http.Client{}
+	cfg := &Config{HttpClient: hc}
+	if _, err := NewRoundTripper(http.DefaultTransport, cfg); err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	if cfg.HttpClient != hc {
+		t.Fatalf("expected the caller's Config to keep its HttpClient")
+	}
+}
@@ -0,0 +1,17 @@
+package retryablehttp
+
+import "time"
+
+// StatsHook receives request statistics from the Client. It is an
+// alternative to, and can be used alongside, the built-in Prometheus
+// metrics enabled by Config.Metrics.
+type StatsHook interface {
+	// Attempt is called after every attempt made by Client.Do with the
+	// attempt number (0 for the initial request), how long the attempt
+	// took and the error returned by the underlying http.Client, if any.
+	Attempt(attempt int, d time.Duration, err error)
+
+	// Done is called once per Client.Do call with its total duration and
+	// the error returned to the caller, if any.
+	Done(d time.Duration, err error)
+}
@@ -0,0 +1,59 @@
+package retryablehttp
+
+import (
+	"sync"
+	"time"
+)
+
+// hostStatus is the outcome of the most recent Do call against a host.
+type hostStatus struct {
+	ok   bool
+	when time.Time
+
+	// failures counts consecutive failed Do calls, reset on success.
+	failures int
+}
+
+// hostStatuses tracks the latest hostStatus per host. The zero value is
+// ready to use.
+type hostStatuses struct {
+	mu sync.RWMutex
+	m  map[string]hostStatus
+}
+
+func (h *hostStatuses) record(host string, ok bool) {
+	h.mu.Lock()
+	if h.m == nil {
+		h.m = make(map[string]hostStatus)
+	}
+	failures := 0
+	if !ok {
+		failures = h.m[host].failures + 1
+	}
+	h.m[host] = hostStatus{ok: ok, when: time.Now(), failures: failures}
+	h.mu.Unlock()
+}
+
+func (h *hostStatuses) get(host string) (hostStatus, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s, found := h.m[host]
+	return s, found
+}
+
+// LastStatus reports whether the most recent Do call against host (as found
+// in the request URL, including any port) returned without error, and when
+// it finished. It is meant as a cheap readiness signal for health endpoints.
+// If no request has been made to host yet, the zero time is returned, as it
+// always is in Lean mode unless AdaptiveHostBackoff is set.
+func (c *Client) LastStatus(host string) (ok bool, when time.Time) {
+	s, _ := c.statuses.get(host)
+	return s.ok, s.when
+}
+
+// consecutiveFailures returns the number of Do calls against host which
+// failed in a row since the last success.
+func (c *Client) consecutiveFailures(host string) int {
+	s, _ := c.statuses.get(host)
+	return s.failures
+}
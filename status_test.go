@@ -0,0 +1,136 @@
+package retryablehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_LastStatus(t *testing.T) {
+	var code int64 = 500
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt64(&code)))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RetryMax = 1
+
+	if ok, when := client.LastStatus(u.Host); ok || !when.IsZero() {
+		t.Fatalf("expected no status before any request, got: %v %v", ok, when)
+	}
+
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatalf("expected error")
+	}
+	ok, failedAt := client.LastStatus(u.Host)
+	if ok || failedAt.IsZero() {
+		t.Fatalf("expected failed status, got: %v %v", ok, failedAt)
+	}
+
+	atomic.StoreInt64(&code, 200)
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	ok, succeededAt := client.LastStatus(u.Host)
+	if !ok || succeededAt.Before(failedAt) {
+		t.Fatalf("expected succeeded status, got: %v %v", ok, succeededAt)
+	}
+}
+
+func TestClient_AdaptiveHostBackoff(t *testing.T) {
+	var code int64 = 500
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt64(&code)))
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{AdaptiveHostBackoff: true, RetryMax: 2})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	var initial []int
+	var first bool
+	client.Backoff = func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		if first {
+			initial = append(initial, attemptNum)
+			first = false
+		}
+		return time.Millisecond
+	}
+
+	get := func() {
+		first = true
+		resp, err := client.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	// Each failing call starts its backoff one step further, up to
+	// RetryMax.
+	get()
+	get()
+	get()
+	get()
+
+	// A success resets the escalation.
+	atomic.StoreInt64(&code, 200)
+	get()
+	atomic.StoreInt64(&code, 500)
+	get()
+
+	expect := []int{0, 1, 2, 2, 0}
+	if len(initial) != len(expect) {
+		t.Fatalf("expected initial attempts %v, got %v", expect, initial)
+	}
+	for i := range expect {
+		if initial[i] != expect[i] {
+			t.Fatalf("expected initial attempts %v, got %v", expect, initial)
+		}
+	}
+}
+
+func TestClient_AdaptiveHostBackoff_linearJitter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		AdaptiveHostBackoff: true,
+		RetryMax:            1,
+		RetryWaitMin:        time.Millisecond,
+		RetryWaitMax:        2 * time.Millisecond,
+		Backoff:             LinearJitterBackoff,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	// Many failed calls don't stretch the waits of later ones.
+	for i := 0; i < 100; i++ {
+		client.Get(ts.URL)
+	}
+	start := time.Now()
+	client.Get(ts.URL)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("expected a wait of at most RetryWaitMax, took %v", elapsed)
+	}
+}
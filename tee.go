@@ -0,0 +1,60 @@
+package retryablehttp
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// teeBody copies a response body to w as it is read, closing w along with
+// the body.
+type teeBody struct {
+	io.Reader
+	body      io.ReadCloser
+	w         io.WriteCloser
+	closeOnce sync.Once
+}
+
+func (t *teeBody) Close() error {
+	err := t.body.Close()
+	t.closeOnce.Do(func() {
+		if werr := t.w.Close(); err == nil {
+			err = werr
+		}
+	})
+	return err
+}
+
+// teeWriter writes to w until a write fails. The error is recorded rather
+// than returned so that it doesn't fail the caller's read of the body.
+type teeWriter struct {
+	w      io.Writer
+	err    error
+	logger Logger
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.err == nil {
+		if _, t.err = t.w.Write(p); t.err != nil {
+			t.logger.Error(t.err.Error())
+		}
+	}
+	return len(p), nil
+}
+
+// teeResponse wraps the body of resp, the response returned for req, so
+// that it is copied to the writer ResponseTee returns for req.
+func (c *Client) teeResponse(req *http.Request, resp *http.Response) {
+	if c.ResponseTee == nil || resp == nil || resp.Body == nil {
+		return
+	}
+	w := c.ResponseTee(req)
+	if w == nil {
+		return
+	}
+	resp.Body = &teeBody{
+		Reader: io.TeeReader(resp.Body, &teeWriter{w: w, logger: c.logger(req.Context())}),
+		body:   resp.Body,
+		w:      w,
+	}
+}
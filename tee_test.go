@@ -0,0 +1,116 @@
+package retryablehttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestClient_ResponseTee(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(500)
+			w.Write([]byte("retried body"))
+			return
+		}
+		w.Write([]byte("final body"))
+	}))
+	defer ts.Close()
+
+	var tees []*closeBuffer
+	client, err := New(&Config{
+		ResponseTee: func(req *http.Request) io.WriteCloser {
+			tee := &closeBuffer{}
+			tees = append(tees, tee)
+			return tee
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if string(body) != "final body" {
+		t.Fatalf("bad body: %q", body)
+	}
+	if len(tees) != 1 {
+		t.Fatalf("expected only the final response to be teed, got %d", len(tees))
+	}
+	if got := tees[0].String(); got != "final body" {
+		t.Fatalf("expected the tee to capture the body, got %q", got)
+	}
+	if !tees[0].closed {
+		t.Fatalf("expected the tee to be closed with the body")
+	}
+}
+
+type failingWriteCloser struct {
+	writes int
+}
+
+func (w *failingWriteCloser) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, io.ErrShortWrite
+}
+
+func (w *failingWriteCloser) Close() error { return nil }
+
+func TestClient_ResponseTee_writeError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), 64<<10))
+	}))
+	defer ts.Close()
+
+	tee := &failingWriteCloser{}
+	client, err := New(&Config{
+		ResponseTee: func(req *http.Request) io.WriteCloser { return tee },
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The audit writer failing doesn't disturb the caller.
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(body) != 64<<10 {
+		t.Fatalf("expected the whole body, got %d bytes", len(body))
+	}
+	if tee.writes != 1 {
+		t.Fatalf("expected copying to stop after the failed write, got %d writes", tee.writes)
+	}
+}
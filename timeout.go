@@ -0,0 +1,42 @@
+package retryablehttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// attemptTimeout returns the timeout of the given attempt, zero meaning
+// none.
+func (c *Client) attemptTimeout(attempt int) time.Duration {
+	if c.PerAttemptTimeoutFunc != nil {
+		return c.PerAttemptTimeoutFunc(attempt)
+	}
+	return c.PerAttemptTimeout
+}
+
+// withAttemptTimeout bounds req by the timeout of the given attempt. The
+// returned func releases the resources of the timeout once the attempt's
+// response body, if any, has been closed.
+func (c *Client) withAttemptTimeout(req *http.Request, attempt int) (*http.Request, context.CancelFunc) {
+	timeout := c.attemptTimeout(attempt)
+	if timeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	return req.WithContext(ctx), cancel
+}
+
+// cancelBody cancels the context of an attempt when its response body is
+// closed, so the body can still be read after the attempt returns.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
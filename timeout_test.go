@@ -0,0 +1,145 @@
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_PerAttemptTimeoutFunc(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer ts.Close()
+
+	var timeouts []time.Duration
+	client, err := New(&Config{
+		PerAttemptTimeout: 10 * time.Millisecond,
+		PerAttemptTimeoutFunc: func(attempt int) time.Duration {
+			timeout := 20 * time.Millisecond << uint(attempt*4)
+			timeouts = append(timeouts, timeout)
+			return timeout
+		},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first attempt timed out and was retried; the second, with a
+	// longer timeout, outlasted the server.
+	if hits := atomic.LoadInt32(&hits); hits != 2 {
+		t.Fatalf("expected 2 requests, got %d", hits)
+	}
+	if len(timeouts) != 2 || timeouts[0] >= 100*time.Millisecond || timeouts[1] <= 100*time.Millisecond {
+		t.Fatalf("bad timeouts: %v", timeouts)
+	}
+
+	// The body can still be read after Do returned.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != "slow" {
+		t.Fatalf("bad body: %q", body)
+	}
+}
+
+func TestClient_PerAttemptTimeout(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{PerAttemptTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	start := time.Now()
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatalf("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected hung attempts to be abandoned, took %s", elapsed)
+	}
+	if hits := atomic.LoadInt32(&hits); hits != 3 {
+		t.Fatalf("expected 3 requests, got %d", hits)
+	}
+}
+
+func TestClient_PerAttemptTimeout_deadlineWins(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{PerAttemptTimeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	start := time.Now()
+	_, err = client.Do(req.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("expected the sooner request deadline to win, took %s", elapsed)
+	}
+}
+
+func TestDefaultRetryPolicy_attemptTimeout(t *testing.T) {
+	// An attempt which timed out on its own, while the request context is
+	// still live, is retried.
+	err := &url.Error{Op: "Get", URL: "http://foo", Err: context.DeadlineExceeded}
+	retry, _ := DefaultRetryPolicy(context.Background(), nil, err)
+	if !retry {
+		t.Fatalf("expected an attempt timeout to be retried")
+	}
+
+	// Once the request context itself is done, it is not.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if retry, _ := DefaultRetryPolicy(ctx, nil, err); retry {
+		t.Fatalf("expected no retry once the request context is done")
+	}
+}
@@ -0,0 +1,158 @@
+package retryablehttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Attempt describes a single attempt made by Client.Do, with a breakdown of
+// where its time went. Phases which did not happen during the attempt, such
+// as DNS and TLS on a reused connection, are zero.
+type Attempt struct {
+	// Number is the attempt number, 0 for the initial request.
+	Number int
+
+	// StatusCode is the response status, or 0 if no response was received.
+	StatusCode int
+
+	// Err is the error returned by the underlying http.Client, if any.
+	Err error
+
+	// ConnReused reports whether the attempt went over a reused connection.
+	ConnReused bool
+
+	// Drained reports whether the response body was read to the end before
+	// retrying, allowing its connection to be reused. It is false for
+	// bodies which were closed instead, and for the final attempt whose
+	// response is handed back to the caller.
+	Drained bool
+
+	DNS          time.Duration // DNS lookup
+	Connect      time.Duration // TCP connect
+	TLSHandshake time.Duration // TLS handshake
+	TTFB         time.Duration // From the start of the attempt to the first response byte
+	Total        time.Duration // Whole attempt, up to the response headers
+}
+
+// Trace collects an Attempt for every attempt of requests made with a
+// context returned by WithTrace.
+type Trace struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+// Attempts returns the attempts recorded so far.
+func (t *Trace) Attempts() []Attempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Attempt(nil), t.attempts...)
+}
+
+// markDrained records that the body of the given attempt was drained.
+func (t *Trace) markDrained(number int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.attempts {
+		if t.attempts[i].Number == number {
+			t.attempts[i].Drained = true
+		}
+	}
+}
+
+func (t *Trace) add(a Attempt) {
+	t.mu.Lock()
+	t.attempts = append(t.attempts, a)
+	t.mu.Unlock()
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx which makes Client.Do record a timing
+// breakdown of every attempt into the returned Trace. Any httptrace.ClientTrace
+// already on ctx keeps being called.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// attemptTiming gathers the httptrace events of one attempt.
+type attemptTiming struct {
+	trace *Trace
+	start time.Time
+
+	mu                               sync.Mutex
+	attempt                          Attempt
+	dnsStart, connectStart, tlsStart time.Time
+}
+
+// traceAttempt returns the request to send for an attempt. When the request
+// context carries a Trace, the returned request is a copy wired with a
+// ClientTrace and the returned timing must be completed with done.
+func traceAttempt(req *http.Request) (*http.Request, *attemptTiming) {
+	t, ok := req.Context().Value(traceKey{}).(*Trace)
+	if !ok {
+		return req, nil
+	}
+
+	at := &attemptTiming{trace: t, start: time.Now()}
+	ct := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			at.mu.Lock()
+			at.attempt.ConnReused = info.Reused
+			at.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			at.mu.Lock()
+			at.dnsStart = time.Now()
+			at.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			at.mu.Lock()
+			at.attempt.DNS = time.Since(at.dnsStart)
+			at.mu.Unlock()
+		},
+		ConnectStart: func(_, _ string) {
+			at.mu.Lock()
+			at.connectStart = time.Now()
+			at.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, _ error) {
+			at.mu.Lock()
+			at.attempt.Connect = time.Since(at.connectStart)
+			at.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			at.mu.Lock()
+			at.tlsStart = time.Now()
+			at.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			at.mu.Lock()
+			at.attempt.TLSHandshake = time.Since(at.tlsStart)
+			at.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			at.mu.Lock()
+			at.attempt.TTFB = time.Since(at.start)
+			at.mu.Unlock()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), ct)), at
+}
+
+// done records the attempt into its Trace.
+func (at *attemptTiming) done(number, code int, err error) {
+	at.mu.Lock()
+	a := at.attempt
+	at.mu.Unlock()
+
+	a.Number = number
+	a.StatusCode = code
+	a.Err = err
+	a.Total = time.Since(at.start)
+	at.trace.add(a)
+}
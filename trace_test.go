@@ -0,0 +1,121 @@
+package retryablehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestWithTrace(t *testing.T) {
+	var hits int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{HttpClient: ts.Client()})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	// An existing trace on the context keeps working.
+	var gotFirstByte int32
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			atomic.AddInt32(&gotFirstByte, 1)
+		},
+	})
+	ctx, trace := WithTrace(ctx)
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	attempts := trace.Attempts()
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %#v", attempts)
+	}
+	if gotFirstByte != 2 {
+		t.Fatalf("expected existing trace to be called twice, got %d", gotFirstByte)
+	}
+
+	for i, a := range attempts {
+		if a.Number != i {
+			t.Fatalf("attempt %d: bad number %d", i, a.Number)
+		}
+		if a.DNS < 0 || a.Connect < 0 || a.TLSHandshake < 0 || a.TTFB < 0 {
+			t.Fatalf("attempt %d: negative duration: %#v", i, a)
+		}
+		if a.TTFB <= 0 || a.TTFB > a.Total {
+			t.Fatalf("attempt %d: bad TTFB: %#v", i, a)
+		}
+	}
+
+	// The first attempt dials and handshakes a new connection.
+	if first := attempts[0]; first.ConnReused || first.Connect <= 0 || first.TLSHandshake <= 0 {
+		t.Fatalf("expected connect and TLS timings on first attempt: %#v", first)
+	}
+	if attempts[0].StatusCode != 503 || attempts[1].StatusCode != 200 {
+		t.Fatalf("bad status codes: %#v", attempts)
+	}
+}
+
+func TestWithTrace_connReuse(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(503)
+			w.Write([]byte("try again"))
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{HttpClient: cleanhttp.DefaultPooledClient()})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	ctx, trace := WithTrace(context.Background())
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	attempts := trace.Attempts()
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %#v", attempts)
+	}
+	if attempts[0].ConnReused || !attempts[0].Drained {
+		t.Fatalf("expected first attempt on a new connection, drained: %#v", attempts[0])
+	}
+	if !attempts[1].ConnReused || attempts[1].Drained {
+		t.Fatalf("expected second attempt to reuse the connection: %#v", attempts[1])
+	}
+}
@@ -0,0 +1,30 @@
+package retryablehttp
+
+import "net/http"
+
+// trailerRetryMatch reports whether the TrailerRetryName trailer of resp is
+// one of TrailerRetryValues. Trailers are only known once the body has been
+// read, so bodies of up to PeekResponseBodyLimit bytes are buffered and
+// restored for the caller; longer ones are not looked at. If reading the
+// body fails, the error is returned.
+func (c *Client) trailerRetryMatch(resp *http.Response) (bool, error) {
+	if resp == nil || resp.Body == nil {
+		return false, nil
+	}
+
+	_, whole, err := readBodyStart(resp, c.PeekResponseBodyLimit)
+	if err != nil || !whole {
+		return false, err
+	}
+
+	value := resp.Trailer.Get(c.TrailerRetryName)
+	if value == "" {
+		return false, nil
+	}
+	for _, v := range c.TrailerRetryValues {
+		if v == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,132 @@
+package retryablehttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_TrailerRetry(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("payload"))
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// UNAVAILABLE
+			w.Header().Set("Grpc-Status", "14")
+			return
+		}
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		TrailerRetryName:   "grpc-status",
+		TrailerRetryValues: []string{"14"},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if hits != 2 {
+		t.Fatalf("expected 2 requests, got %d", hits)
+	}
+	if v := resp.Trailer.Get("Grpc-Status"); v != "0" {
+		t.Fatalf("expected grpc-status 0, got %q", v)
+	}
+
+	// The body of the final response is still readable.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(body) != "payload" {
+		t.Fatalf("bad body: %q", body)
+	}
+}
+
+func TestClient_TrailerRetry_readError(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// Cut the body short.
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("pay"))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("payload"))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		TrailerRetryName:   "grpc-status",
+		TrailerRetryValues: []string{"14"},
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The truncated body failed the attempt instead of being returned.
+	if hits != 2 || string(body) != "payload" {
+		t.Fatalf("expected the second, whole body, got %q after %d requests", body, hits)
+	}
+}
+
+func TestClient_TrailerRetry_limit(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("a long payload"))
+		w.Header().Set("Grpc-Status", "14")
+	}))
+	defer ts.Close()
+
+	client, err := New(&Config{
+		TrailerRetryName:      "grpc-status",
+		TrailerRetryValues:    []string{"14"},
+		PeekResponseBodyLimit: 4,
+	})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if hits != 1 || string(body) != "a long payload" {
+		t.Fatalf("expected the long body to be returned whole, got %q after %d requests", body, hits)
+	}
+}
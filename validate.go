@@ -0,0 +1,63 @@
+package retryablehttp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Validate runs req through the same preparation Do would, including the
+// request modifiers, and checks the resulting request for problems without
+// performing any network I/O. It is meant to catch request-building
+// mistakes in tests and CI.
+//
+// Validate reads the body once through its factory to verify the
+// Content-Length, so it should not be used with bodies which can only be
+// produced once.
+func (c *Client) Validate(req *Request) error {
+	if req == nil || req.Request == nil {
+		return fmt.Errorf("nil request")
+	}
+
+	if c.RequestModifier != nil || len(c.RequestModifiers) > 0 {
+		req = c.modifyRequest(req.clone())
+		if req == nil || req.Request == nil {
+			return fmt.Errorf("request modifier returned a nil request")
+		}
+	}
+
+	if req.URL == nil {
+		return fmt.Errorf("%s: missing URL", req.Method)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%s %s: unsupported protocol scheme %q", req.Method, req.URL, req.URL.Scheme)
+	}
+	if req.URL.Host == "" {
+		return fmt.Errorf("%s %s: missing host", req.Method, req.URL)
+	}
+
+	if req.body == nil {
+		if req.ContentLength > 0 {
+			return fmt.Errorf("%s %s: Content-Length %d set without a body",
+				req.Method, req.URL, req.ContentLength)
+		}
+		return nil
+	}
+
+	body, err := req.body()
+	if err != nil {
+		return fmt.Errorf("%s %s: reading body: %v", req.Method, req.URL, err)
+	}
+	n, err := io.Copy(ioutil.Discard, body)
+	if c, ok := body.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: reading body: %v", req.Method, req.URL, err)
+	}
+	if req.ContentLength > 0 && n != req.ContentLength {
+		return fmt.Errorf("%s %s: Content-Length %d does not match body length %d",
+			req.Method, req.URL, req.ContentLength, n)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package retryablehttp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestClient_Validate(t *testing.T) {
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	req, err := NewRequest("POST", "http://example.com/foo", []byte("hello"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := client.Validate(req); err != nil {
+		t.Fatalf("expected valid request, got: %v", err)
+	}
+}
+
+func TestClient_Validate_malformed(t *testing.T) {
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	newReq := func(url string, body interface{}) *Request {
+		req, err := NewRequest("POST", url, body)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return req
+	}
+
+	failing := false
+	brokenBody := newReq("http://example.com", ReaderFunc(func() (io.Reader, error) {
+		if failing {
+			return nil, errors.New("cursor closed")
+		}
+		return strings.NewReader("x"), nil
+	}))
+	failing = true
+
+	wrongLength := newReq("http://example.com", []byte("hello"))
+	wrongLength.ContentLength = 10
+
+	cases := []struct {
+		name   string
+		req    *Request
+		expect string
+	}{
+		{"missing host", newReq("http:///foo", nil), "missing host"},
+		{"relative URL", newReq("/foo", nil), "unsupported protocol scheme"},
+		{"bad scheme", newReq("ftp://example.com", nil), "unsupported protocol scheme"},
+		{"body factory error", brokenBody, "cursor closed"},
+		{"content length mismatch", wrongLength, "does not match body length"},
+	}
+
+	for _, tc := range cases {
+		err := client.Validate(tc.req)
+		if err == nil || !strings.Contains(err.Error(), tc.expect) {
+			t.Fatalf("%s: expected error containing %q, got: %v", tc.name, tc.expect, err)
+		}
+	}
+}
+
+func TestClient_Validate_requestModifier(t *testing.T) {
+	client, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+	client.RequestModifier = func(req *Request) *Request {
+		req.URL.Host = ""
+		return req
+	}
+
+	req, err := NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := client.Validate(req); err == nil || !strings.Contains(err.Error(), "missing host") {
+		t.Fatalf("expected modifier output to be validated, got: %v", err)
+	}
+
+	// The modifier worked on a copy.
+	if req.URL.Host != "example.com" {
+		t.Fatalf("expected the caller's request to be left alone, got host %q", req.URL.Host)
+	}
+}
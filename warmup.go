@@ -0,0 +1,48 @@
+package retryablehttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/lalamove/nui/nlogger"
+)
+
+// Warmup primes the connection pool before a latency-sensitive burst by
+// sending n concurrent HEAD requests to url, each of which opens a
+// connection left idle for later requests. The requests bypass retries,
+// hooks and metrics, and failures are only logged. Warmup returns once all
+// requests are done or ctx is cancelled.
+//
+// Warming up only helps with an HttpClient which keeps idle connections
+// around, such as cleanhttp.DefaultPooledClient, and no more connections are
+// kept than its transport's MaxIdleConnsPerHost allows.
+func (c *Client) Warmup(ctx context.Context, url string, n int) {
+	if err := c.lazyInit(); err != nil {
+		return
+	}
+	httpClient := c.httpClient()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequest("HEAD", url, nil)
+			if err != nil {
+				c.logger(ctx).Error(err.Error())
+				return
+			}
+			resp, err := httpClient.Do(req.WithContext(ctx))
+			if err != nil {
+				c.logger(ctx).DebugWithFields("warmup request failed", func(entry nlogger.Entry) {
+					entry.String("url", url)
+					entry.Err("error", err)
+				})
+				return
+			}
+			c.drainBody(resp.Body)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,63 @@
+package retryablehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestClient_Warmup(t *testing.T) {
+	const n = 4
+
+	// Hold every request until all of them have arrived, so that each one
+	// needs its own connection.
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	var conns, hits int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" && atomic.AddInt32(&hits, 1) <= n {
+			arrived.Done()
+			arrived.Wait()
+		}
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	client, err := New(&Config{HttpClient: cleanhttp.DefaultPooledClient()})
+	if err != nil {
+		t.Fatalf("Err: %#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client.Warmup(ctx, ts.URL, n)
+
+	if conns != n {
+		t.Fatalf("expected %d connections, got %d", n, conns)
+	}
+	if v := client.TotalRequests(); v != 0 {
+		t.Fatalf("expected warmup not to count as requests, got %d", v)
+	}
+
+	// Subsequent requests reuse the warm connections.
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if conns != n {
+		t.Fatalf("expected a warm connection to be reused, got %d connections", conns)
+	}
+}